@@ -0,0 +1,150 @@
+// Package analysis normalises text into index terms the same way as the analyzers cparser indexes
+// text fields with, so that terms can be counted, expanded or exported outside Elasticsearch. Text
+// is split into words, lowercased, stopped and stemmed. Only the Porter stemmer is implemented;
+// there is no equivalent of the kstem filter, which needs KStem's dictionary.
+package analysis
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// isWord reports whether a rune is part of a word.
+func isWord(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.Is(unicode.Mn, r)
+}
+
+// isMid reports whether a rune joins the words either side of it into one, e.g., don't, U.S.A or
+// 3.14, as it does in the Unicode word boundaries of the standard tokenizer. Commas only join
+// numbers.
+func isMid(r rune, prev, next rune) bool {
+	switch r {
+	case '\'', '’', '.':
+		return true
+	case ',':
+		return unicode.IsNumber(prev) && unicode.IsNumber(next)
+	}
+	return false
+}
+
+// Tokenize splits text into lower-case words. Words are runs of letters and numbers, which may be
+// joined by an apostrophe or full stop (or by a comma between numbers), approximating the
+// Unicode word boundaries of the Elasticsearch standard tokenizer. Curly apostrophes are
+// normalised to straight ones.
+func Tokenize(text string) []string {
+	var (
+		tokens []string
+		word   []rune
+		runes  = []rune(text)
+	)
+	for i, r := range runes {
+		if isWord(r) {
+			word = append(word, r)
+			continue
+		}
+		if len(word) > 0 && i+1 < len(runes) && isWord(runes[i+1]) && isMid(r, runes[i-1], runes[i+1]) {
+			if r == '’' {
+				r = '\''
+			}
+			word = append(word, r)
+			continue
+		}
+		if len(word) > 0 {
+			tokens = append(tokens, strings.ToLower(string(word)))
+			word = word[:0]
+		}
+	}
+	if len(word) > 0 {
+		tokens = append(tokens, strings.ToLower(string(word)))
+	}
+	return tokens
+}
+
+// Stemmer reduces a lower-case word to its stem.
+type Stemmer func(word string) string
+
+// StopList is a set of lower-case words which are not indexed.
+type StopList map[string]bool
+
+// newStopList creates a stop list from its words.
+func newStopList(words ...string) StopList {
+	s := make(StopList, len(words))
+	for _, w := range words {
+		s[w] = true
+	}
+	return s
+}
+
+// Stemmers are the stemmers which text can be analysed with, by name.
+var Stemmers = map[string]Stemmer{
+	"porter": Porter,
+}
+
+// StopLists are the stop lists which text can be analysed with, by name.
+var StopLists = map[string]StopList{
+	"lucene":  LuceneStopwords,
+	"smart":   SMARTStopwords,
+	"inquery": INQUERYStopwords,
+}
+
+// Analyzer turns text into index terms. Words in Stopwords are removed, and the rest are stemmed
+// with Stem, if either is set.
+type Analyzer struct {
+	Stopwords StopList
+	Stem      Stemmer
+}
+
+// Analyze tokenizes text, then stops and stems the words.
+func (a Analyzer) Analyze(text string) []string {
+	words := Tokenize(text)
+	terms := words[:0]
+	for _, w := range words {
+		if a.Stopwords[w] {
+			continue
+		}
+		if a.Stem != nil {
+			w = a.Stem(w)
+		}
+		terms = append(terms, w)
+	}
+	return terms
+}
+
+// names lists the keys of a map, for error messages.
+func names(m map[string]bool) string {
+	var n []string
+	for k := range m {
+		n = append(n, k)
+	}
+	sort.Strings(n)
+	return strings.Join(n, ", ")
+}
+
+// Named returns the analyzer with the same name as one of the analyzers cparser maps text fields
+// with: raw (lowercased words), stopped, or porter (stopped and Porter stemmed). Words are stopped
+// with the named stop list.
+func Named(name, stopwords string) (Analyzer, error) {
+	stop, ok := StopLists[stopwords]
+	if !ok {
+		lists := make(map[string]bool)
+		for k := range StopLists {
+			lists[k] = true
+		}
+		return Analyzer{}, fmt.Errorf("%s is not a known stop list (%s)", stopwords, names(lists))
+	}
+	switch name {
+	case "raw":
+		return Analyzer{}, nil
+	case "stopped":
+		return Analyzer{Stopwords: stop}, nil
+	case "porter":
+		return Analyzer{Stopwords: stop, Stem: Porter}, nil
+	case "krovetz":
+		return Analyzer{}, fmt.Errorf("krovetz (the kstem filter) can only be used by Elasticsearch")
+	}
+	return Analyzer{}, fmt.Errorf("%s is not a known analyzer (%s)", name, names(map[string]bool{
+		"raw": true, "stopped": true, "porter": true,
+	}))
+}
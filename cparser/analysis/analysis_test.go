@@ -0,0 +1,68 @@
+package analysis
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	for text, tokens := range map[string]string{
+		"":                            "",
+		"  \t\n":                      "",
+		"The Quick, brown FOX!":       "the quick brown fox",
+		"don't won’t":                 "don't won't",
+		"U.S.A. is 3.14 or 1,000,000": "u.s.a is 3.14 or 1,000,000",
+		"one,two 3, 4":                "one two 3 4",
+		"e-mail (and) x/y":            "e mail and x y",
+		"'quoted' .end. trailing'":    "quoted end trailing",
+		"Café naïve Straße 東京":        "café naïve straße 東京",
+		"nai\u0308ve":                 "nai\u0308ve", // Combining marks are part of a word.
+		"tab\tseparated\nlines":       "tab separated lines",
+		"hyphen--dash...ellipsis":     "hyphen dash ellipsis",
+		"<b>tags</b>&amp;":            "b tags b amp",
+		"A.B":                         "a.b",
+		"x'":                          "x",
+		"'x":                          "x",
+		"50%":                         "50",
+		"9am-5pm":                     "9am 5pm",
+	} {
+		if got := strings.Join(Tokenize(text), " "); got != tokens {
+			t.Errorf("Tokenize(%q) = %q, expected %q", text, got, tokens)
+		}
+	}
+}
+
+func TestStopListSizes(t *testing.T) {
+	for name, size := range map[string]int{"lucene": 33, "smart": 570, "inquery": 418} {
+		if n := len(StopLists[name]); n != size {
+			t.Errorf("the %s stop list has %d words, expected %d", name, n, size)
+		}
+	}
+}
+
+func TestNamed(t *testing.T) {
+	text := "The runners were running in the races"
+	for _, c := range []struct {
+		name, stopwords, terms string
+	}{
+		{"raw", "lucene", "the runners were running in the races"},
+		{"stopped", "lucene", "runners were running races"},
+		{"stopped", "smart", "runners running races"},
+		{"porter", "lucene", "runner were run race"},
+		{"porter", "inquery", "runner run race"},
+	} {
+		a, err := Named(c.name, c.stopwords)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if terms := strings.Join(a.Analyze(text), " "); terms != c.terms {
+			t.Errorf("%s analyzer with %s stop words: %q, expected %q", c.name, c.stopwords, terms, c.terms)
+		}
+	}
+
+	for _, c := range [][2]string{{"krovetz", "lucene"}, {"english", "lucene"}, {"porter", "none"}} {
+		if _, err := Named(c[0], c[1]); err == nil {
+			t.Errorf("Named(%q, %q) did not return an error", c[0], c[1])
+		}
+	}
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// DocumentWriter receives parsed documents and writes them somewhere.
+type DocumentWriter interface {
+	Write(d Document) error
+	Close() error
+}
+
+// OpType is the bulk operation used to write documents.
+type OpType string
+
+const (
+	OpIndex  OpType = "index"  // Documents are added or replaced.
+	OpCreate OpType = "create" // Documents are added, failing if they already exist.
+	OpUpdate OpType = "update" // Documents are updated, or added if they do not exist.
+)
+
+// ParseOpType checks that a bulk operation is known.
+func ParseOpType(s string) (OpType, error) {
+	switch op := OpType(s); op {
+	case OpIndex, OpCreate, OpUpdate:
+		return op, nil
+	}
+	return "", fmt.Errorf("%s is not a known op type (index, create or update)", s)
+}
+
+// BulkAction describes how documents are written in a bulk request. When Routing is set, it
+// names the document field whose value is used to route a document to a shard; documents without
+// the field are not routed. Pipeline is the ingest pipeline documents are sent through.
+type BulkAction struct {
+	Index    string
+	Op       OpType
+	Routing  string
+	Pipeline string
+}
+
+// actionMetadata is the metadata of a bulk action line.
+type actionMetadata struct {
+	Index    string `json:"_index"`
+	ID       string `json:"_id"`
+	Routing  string `json:"routing,omitempty"`
+	Pipeline string `json:"pipeline,omitempty"`
+}
+
+// upsert is the body of an update action which adds the document if it does not exist.
+type upsert struct {
+	Doc         map[string]interface{} `json:"doc"`
+	DocAsUpsert bool                   `json:"doc_as_upsert"`
+}
+
+// Encode creates the action line and body of a bulk action for a document.
+func (a BulkAction) Encode(d Document) (action []byte, body []byte, err error) {
+	op := a.Op
+	if len(op) == 0 {
+		op = OpIndex
+	}
+	m := actionMetadata{
+		Index:    a.Index,
+		ID:       d.ID,
+		Pipeline: a.Pipeline,
+	}
+	if v, ok := d.Fields[a.Routing]; ok && len(a.Routing) > 0 {
+		m.Routing = fmt.Sprint(v)
+	}
+	action, err = json.Marshal(map[OpType]actionMetadata{op: m})
+	if err != nil {
+		return nil, nil, err
+	}
+	if op == OpUpdate {
+		body, err = json.Marshal(upsert{Doc: d.Fields, DocAsUpsert: true})
+	} else {
+		body, err = d.Source()
+	}
+	return action, body, err
+}
+
+// StdoutWriter writes bulk actions to stdout as NDJSON.
+type StdoutWriter struct {
+	BulkAction
+}
+
+func (w StdoutWriter) Write(d Document) error {
+	action, body, err := w.Encode(d)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.WriteString(fmt.Sprintf("%s\n%s\n", action, body))
+	return err
+}
+
+func (w StdoutWriter) Close() error {
+	return nil
+}
+
+// bulkItem is a single document waiting to be sent in a bulk request.
+type bulkItem struct {
+	id     string
+	path   string // Collection file the document was parsed from, if known.
+	action string
+	data   []byte
+}
+
+// bulkResponse is the body Elasticsearch returns from the _bulk endpoint.
+type bulkResponse struct {
+	Took   int                           `json:"took"`
+	Errors bool                          `json:"errors"`
+	Items  []map[string]bulkResponseItem `json:"items"`
+}
+
+type bulkResponseItem struct {
+	Index  string          `json:"_index"`
+	ID     string          `json:"_id"`
+	Status int             `json:"status"`
+	Error  json.RawMessage `json:"error,omitempty"`
+}
+
+// deadLetter is a document that could not be indexed.
+type deadLetter struct {
+	ID     string          `json:"id"`
+	Status int             `json:"status"`
+	Error  json.RawMessage `json:"error,omitempty"`
+	Action json.RawMessage `json:"action,omitempty"`
+	Path   string          `json:"path,omitempty"`
+	Doc    json.RawMessage `json:"doc"`
+}
+
+// BulkIndexer sends documents to the Elasticsearch _bulk endpoint. Documents are buffered and
+// flushed once either MaxDocs or MaxBytes is reached. Items rejected with 429 or 503 are retried
+// with exponential backoff, and any other failures are written to the dead-letter file. When
+// Checkpoint is set, it is told which documents of each collection file have been resolved.
+type BulkIndexer struct {
+	BulkAction
+	URL        string
+	MaxDocs    int
+	MaxBytes   int
+	MaxRetries int
+	Backoff    time.Duration
+	DeadLetter string
+	Client     *http.Client
+	Checkpoint *Checkpoint
+
+	Parsed   int
+	Indexed  int
+	Existing int // Documents which were not created since they already exist.
+	Failed   int
+
+	items []bulkItem
+	size  int
+	dlq   *os.File
+}
+
+// NewBulkIndexer creates a bulk indexer for the index at the Elasticsearch url.
+func NewBulkIndexer(url, index string) *BulkIndexer {
+	return &BulkIndexer{
+		BulkAction: BulkAction{Index: index, Op: OpIndex},
+		URL:        strings.TrimRight(url, "/"),
+		MaxDocs:    1000,
+		MaxBytes:   5 << 20,
+		MaxRetries: 5,
+		Backoff:    500 * time.Millisecond,
+		Client:     http.DefaultClient,
+	}
+}
+
+// Write adds a document to the current batch, flushing it if it is full.
+func (b *BulkIndexer) Write(d Document) error {
+	action, data, err := b.Encode(d)
+	if err != nil {
+		return err
+	}
+	item := bulkItem{
+		id:     d.ID,
+		path:   d.Metadata["path"],
+		action: string(action),
+		data:   data,
+	}
+	b.Parsed++
+	if b.Checkpoint != nil {
+		b.Checkpoint.Sent(item.path)
+	}
+	return b.add(item)
+}
+
+// add adds an item to the current batch, flushing it if it is full.
+func (b *BulkIndexer) add(item bulkItem) error {
+	b.items = append(b.items, item)
+	b.size += len(item.action) + len(item.data) + 2
+	if len(b.items) >= b.MaxDocs || b.size >= b.MaxBytes {
+		return b.Flush()
+	}
+	return nil
+}
+
+// Flush sends all the buffered documents to Elasticsearch.
+func (b *BulkIndexer) Flush() error {
+	items := b.items
+	b.items = nil
+	b.size = 0
+
+	backoff := b.Backoff
+	for attempt := 0; len(items) > 0; attempt++ {
+		retry, err := b.send(items, attempt >= b.MaxRetries)
+		if err != nil {
+			return err
+		}
+		if len(retry) > 0 && attempt < b.MaxRetries {
+			log.Printf("retrying %d documents in %s\n", len(retry), backoff)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		items = retry
+	}
+	return nil
+}
+
+// send issues a single bulk request and returns the items which should be retried. When last is
+// true, no items are retried and they are instead written to the dead-letter file.
+func (b *BulkIndexer) send(items []bulkItem, last bool) ([]bulkItem, error) {
+	body := new(bytes.Buffer)
+	for _, item := range items {
+		body.WriteString(item.action)
+		body.WriteByte('\n')
+		body.Write(item.data)
+		body.WriteByte('\n')
+	}
+
+	resp, err := b.Client.Post(b.URL+"/_bulk", "application/x-ndjson", body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// The whole request was rejected, so either retry all of it or give up on all of it.
+	if resp.StatusCode != http.StatusOK {
+		if retryable(resp.StatusCode) && !last {
+			return items, nil
+		}
+		for _, item := range items {
+			err = b.fail(item, resp.StatusCode, nil)
+			if err != nil {
+				return nil, err
+			}
+		}
+		log.Printf("bulk request failed with status %d: %s\n", resp.StatusCode, respBody)
+		return nil, nil
+	}
+
+	var r bulkResponse
+	err = json.Unmarshal(respBody, &r)
+	if err != nil {
+		return nil, err
+	}
+	if len(r.Items) != len(items) {
+		return nil, fmt.Errorf("bulk response contains %d items, expected %d", len(r.Items), len(items))
+	}
+
+	var retry []bulkItem
+	for i, result := range r.Items {
+		for _, res := range result {
+			switch {
+			case res.Status >= 200 && res.Status < 300:
+				// A document which replaced (or updated) one with the same id is 200 OK,
+				// rather than 201 Created.
+				b.Indexed++
+				err = b.resolved(items[i], true, res.Status != http.StatusCreated)
+			case res.Status == http.StatusConflict && b.Op == OpCreate:
+				b.Existing++
+				err = b.resolved(items[i], true, true)
+			case retryable(res.Status) && !last:
+				retry = append(retry, items[i])
+			default:
+				err = b.fail(items[i], res.Status, res.Error)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return retry, nil
+}
+
+// resolved tells the checkpoint that a document has been indexed or rejected, and whether the
+// index already had a document with its id.
+func (b *BulkIndexer) resolved(item bulkItem, indexed, existed bool) error {
+	if b.Checkpoint == nil {
+		return nil
+	}
+	return b.Checkpoint.Resolved(item.path, indexed, existed)
+}
+
+// fail records a document which could not be indexed.
+func (b *BulkIndexer) fail(item bulkItem, status int, reason json.RawMessage) error {
+	b.Failed++
+	if len(b.DeadLetter) == 0 {
+		return b.resolved(item, false, false)
+	}
+	if b.dlq == nil {
+		f, err := os.OpenFile(b.DeadLetter, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		b.dlq = f
+	}
+	doc := json.RawMessage(item.data)
+	if !json.Valid(doc) {
+		d, err := json.Marshal(string(item.data))
+		if err != nil {
+			return err
+		}
+		doc = d
+	}
+	err := json.NewEncoder(b.dlq).Encode(deadLetter{
+		ID:     item.id,
+		Status: status,
+		Error:  reason,
+		Action: json.RawMessage(item.action),
+		Path:   item.path,
+		Doc:    doc,
+	})
+	if err != nil {
+		return err
+	}
+	// The document is only resolved once it has been kept, so that it can be re-sent.
+	return b.resolved(item, false, false)
+}
+
+// Close flushes any remaining documents and reports how many documents were indexed.
+func (b *BulkIndexer) Close() error {
+	err := b.Flush()
+	if err == nil && b.Checkpoint != nil {
+		err = b.Checkpoint.Flushed()
+	}
+	if b.dlq != nil {
+		if cerr := b.dlq.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if b.Existing > 0 {
+		log.Printf("%d parsed documents already exist\n", b.Existing)
+	}
+	log.Printf("indexed %d/%d parsed documents (%d failed)\n", b.Indexed, b.Parsed, b.Failed)
+	return err
+}
+
+// retryable reports whether a bulk item with this status may succeed if sent again.
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// bulkServer is a stand-in for the Elasticsearch _bulk endpoint. Each document is given the
+// statuses in fail in turn, and is indexed once they run out: created the first time its id is
+// seen, and replaced after that.
+type bulkServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	fail     map[string][]int
+	indexed  map[string]bool
+	requests [][]string // Ids of the documents in each request.
+}
+
+func newBulkServer(t *testing.T, fail map[string][]int) *bulkServer {
+	s := &bulkServer{fail: fail, indexed: make(map[string]bool)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_bulk" {
+			http.NotFound(w, r)
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		var (
+			ids   []string
+			resp  = bulkResponse{}
+			lines = bufio.NewScanner(r.Body)
+		)
+		for lines.Scan() {
+			var action map[OpType]actionMetadata
+			if err := json.Unmarshal(lines.Bytes(), &action); err != nil {
+				t.Errorf("bad action line %q: %v", lines.Text(), err)
+				return
+			}
+			if !lines.Scan() {
+				t.Errorf("action without a document")
+				return
+			}
+			for op, m := range action {
+				ids = append(ids, m.ID)
+				item := bulkResponseItem{Index: m.Index, ID: m.ID, Status: http.StatusCreated}
+				if statuses := s.fail[m.ID]; len(statuses) > 0 {
+					item.Status = statuses[0]
+					item.Error = json.RawMessage(fmt.Sprintf(`{"type":"error_%d"}`, item.Status))
+					s.fail[m.ID] = statuses[1:]
+					resp.Errors = true
+				} else if s.indexed[m.ID] {
+					item.Status = http.StatusOK
+				}
+				s.indexed[m.ID] = s.indexed[m.ID] || item.Status < 300
+				resp.Items = append(resp.Items, map[string]bulkResponseItem{string(op): item})
+			}
+		}
+		s.requests = append(s.requests, ids)
+		json.NewEncoder(w).Encode(resp)
+	}))
+	return s
+}
+
+// testIndexer creates a bulk indexer for the server which does not wait between retries.
+func testIndexer(s *bulkServer) *BulkIndexer {
+	b := NewBulkIndexer(s.URL, "test")
+	b.Backoff = 0
+	return b
+}
+
+func writeDocs(t *testing.T, b *BulkIndexer, n int) {
+	for i := 0; i < n; i++ {
+		err := b.Write(Document{ID: fmt.Sprintf("d%d", i), Fields: map[string]interface{}{"text": "some text"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBulkIndexerFlushesByDocs(t *testing.T) {
+	s := newBulkServer(t, nil)
+	defer s.Close()
+	b := testIndexer(s)
+	b.MaxDocs = 3
+	writeDocs(t, b, 7)
+
+	var sizes []int
+	for _, r := range s.requests {
+		sizes = append(sizes, len(r))
+	}
+	if fmt.Sprint(sizes) != "[3 3 1]" {
+		t.Errorf("requests of %v documents, expected [3 3 1]", sizes)
+	}
+	if b.Indexed != 7 || b.Parsed != 7 || b.Failed != 0 {
+		t.Errorf("indexed %d/%d (%d failed), expected 7/7 (0 failed)", b.Indexed, b.Parsed, b.Failed)
+	}
+}
+
+func TestBulkIndexerFlushesByBytes(t *testing.T) {
+	s := newBulkServer(t, nil)
+	defer s.Close()
+	b := testIndexer(s)
+
+	// Each document is 60 bytes with its action, so a request is full after two.
+	b.MaxBytes = 120
+	writeDocs(t, b, 5)
+
+	var sizes []int
+	for _, r := range s.requests {
+		sizes = append(sizes, len(r))
+	}
+	if fmt.Sprint(sizes) != "[2 2 1]" {
+		t.Errorf("requests of %v documents, expected [2 2 1]", sizes)
+	}
+}
+
+func TestBulkIndexerRetries(t *testing.T) {
+	s := newBulkServer(t, map[string][]int{
+		"d1": {http.StatusTooManyRequests},
+		"d3": {http.StatusServiceUnavailable, http.StatusTooManyRequests},
+	})
+	defer s.Close()
+	b := testIndexer(s)
+	writeDocs(t, b, 5)
+
+	if len(s.requests) != 3 {
+		t.Fatalf("sent %d requests, expected 3", len(s.requests))
+	}
+	if got := strings.Join(s.requests[1], ","); got != "d1,d3" {
+		t.Errorf("retried %s, expected d1,d3", got)
+	}
+	if got := strings.Join(s.requests[2], ","); got != "d3" {
+		t.Errorf("retried %s, expected d3", got)
+	}
+	if b.Indexed != 5 || b.Parsed != 5 || b.Failed != 0 {
+		t.Errorf("indexed %d/%d (%d failed), expected 5/5 (0 failed)", b.Indexed, b.Parsed, b.Failed)
+	}
+}
+
+func TestBulkIndexerDeadLetters(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	s := newBulkServer(t, map[string][]int{
+		"d0": {http.StatusBadRequest},
+		"d2": {http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusTooManyRequests},
+	})
+	defer s.Close()
+	b := testIndexer(s)
+	b.MaxRetries = 2
+	b.DeadLetter = filepath.Join(dir, "failed.jsonl")
+	writeDocs(t, b, 4)
+
+	if b.Indexed != 2 || b.Parsed != 4 || b.Failed != 2 {
+		t.Errorf("indexed %d/%d (%d failed), expected 2/4 (2 failed)", b.Indexed, b.Parsed, b.Failed)
+	}
+
+	data, err := ioutil.ReadFile(b.DeadLetter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var letters []deadLetter
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var dl deadLetter
+		if err := json.Unmarshal([]byte(line), &dl); err != nil {
+			t.Fatal(err)
+		}
+		letters = append(letters, dl)
+	}
+	if len(letters) != 2 {
+		t.Fatalf("%d dead letters, expected 2", len(letters))
+	}
+	for i, expected := range []struct {
+		id     string
+		status int
+	}{{"d0", http.StatusBadRequest}, {"d2", http.StatusTooManyRequests}} {
+		dl := letters[i]
+		if dl.ID != expected.id || dl.Status != expected.status {
+			t.Errorf("dead letter %s with status %d, expected %s with %d", dl.ID, dl.Status, expected.id, expected.status)
+		}
+		if len(dl.Error) == 0 || len(dl.Action) == 0 {
+			t.Errorf("dead letter %s is missing its error or action", dl.ID)
+		}
+		var doc map[string]interface{}
+		if err := json.Unmarshal(dl.Doc, &doc); err != nil || doc["text"] != "some text" {
+			t.Errorf("dead letter %s has document %s", dl.ID, dl.Doc)
+		}
+	}
+}
+
+func TestBulkIndexerRejectedRequest(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"bad request"}`, http.StatusBadRequest)
+	}))
+	defer s.Close()
+	b := NewBulkIndexer(s.URL, "test")
+	for i := 0; i < 3; i++ {
+		if err := b.Write(Document{ID: fmt.Sprint(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if b.Indexed != 0 || b.Parsed != 3 || b.Failed != 3 {
+		t.Errorf("indexed %d/%d (%d failed), expected 0/3 (3 failed)", b.Indexed, b.Parsed, b.Failed)
+	}
+}
+
+func TestBulkActionEncode(t *testing.T) {
+	doc := Document{ID: `FT"1\2`, Fields: map[string]interface{}{"text": "a \"quoted\"\nline", "source": "ft"}}
+	for _, c := range []struct {
+		name       string
+		action     BulkAction
+		line, body string
+	}{
+		{
+			"index", BulkAction{Index: "robust04"},
+			`{"index":{"_index":"robust04","_id":"FT\"1\\2"}}`,
+			`{"source":"ft","text":"a \"quoted\"\nline"}`,
+		},
+		{
+			"create", BulkAction{Index: "robust04", Op: OpCreate},
+			`{"create":{"_index":"robust04","_id":"FT\"1\\2"}}`,
+			`{"source":"ft","text":"a \"quoted\"\nline"}`,
+		},
+		{
+			"update", BulkAction{Index: "robust04", Op: OpUpdate},
+			`{"update":{"_index":"robust04","_id":"FT\"1\\2"}}`,
+			`{"doc":{"source":"ft","text":"a \"quoted\"\nline"},"doc_as_upsert":true}`,
+		},
+		{
+			"routing and pipeline", BulkAction{Index: "robust04", Routing: "source", Pipeline: "clean"},
+			`{"index":{"_index":"robust04","_id":"FT\"1\\2","routing":"ft","pipeline":"clean"}}`,
+			`{"source":"ft","text":"a \"quoted\"\nline"}`,
+		},
+		{
+			"missing routing field", BulkAction{Index: "robust04", Routing: "section"},
+			`{"index":{"_index":"robust04","_id":"FT\"1\\2"}}`,
+			`{"source":"ft","text":"a \"quoted\"\nline"}`,
+		},
+	} {
+		line, body, err := c.action.Encode(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(line) != c.line {
+			t.Errorf("%s: action %s, expected %s", c.name, line, c.line)
+		}
+		if string(body) != c.body {
+			t.Errorf("%s: body %s, expected %s", c.name, body, c.body)
+		}
+
+		// Each is a single line of valid JSON, so the NDJSON stays valid.
+		for _, b := range [][]byte{line, body} {
+			var v map[string]interface{}
+			if strings.ContainsRune(string(b), '\n') || json.Unmarshal(b, &v) != nil {
+				t.Errorf("%s: %s is not a line of JSON", c.name, b)
+			}
+		}
+		var a map[OpType]actionMetadata
+		if err := json.Unmarshal(line, &a); err != nil || len(a) != 1 {
+			t.Fatalf("%s: %s", c.name, line)
+		}
+		for _, m := range a {
+			if m.ID != doc.ID {
+				t.Errorf("%s: the id was decoded as %q", c.name, m.ID)
+			}
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Charsets which text can be transcoded from. Following the WHATWG encoding standard, text labelled
+// as ISO-8859-1 or ASCII is decoded as windows-1252, which it almost always is.
+const (
+	UTF8Charset        = "utf-8"
+	Windows1252Charset = "windows-1252"
+)
+
+// charsetLabels maps the labels used to declare a charset to the charsets which are supported.
+var charsetLabels = map[string]string{
+	"utf-8":             UTF8Charset,
+	"utf8":              UTF8Charset,
+	"unicode-1-1-utf-8": UTF8Charset,
+	"windows-1252":      Windows1252Charset,
+	"cp1252":            Windows1252Charset,
+	"x-cp1252":          Windows1252Charset,
+	"iso-8859-1":        Windows1252Charset,
+	"iso8859-1":         Windows1252Charset,
+	"iso88591":          Windows1252Charset,
+	"iso_8859-1":        Windows1252Charset,
+	"iso_8859-1:1987":   Windows1252Charset,
+	"iso-ir-100":        Windows1252Charset,
+	"latin1":            Windows1252Charset,
+	"l1":                Windows1252Charset,
+	"csisolatin1":       Windows1252Charset,
+	"ibm819":            Windows1252Charset,
+	"cp819":             Windows1252Charset,
+	"ascii":             Windows1252Charset,
+	"us-ascii":          Windows1252Charset,
+	"ansi_x3.4-1968":    Windows1252Charset,
+}
+
+// cp1252 are the characters of windows-1252 bytes 0x80 to 0x9f. Bytes 0xa0 to 0xff are the same
+// as their code points, and the bytes which are not defined are 0.
+var cp1252 = [32]rune{
+	0x20ac, 0, 0x201a, 0x0192, 0x201e, 0x2026, 0x2020, 0x2021,
+	0x02c6, 0x2030, 0x0160, 0x2039, 0x0152, 0, 0x017d, 0,
+	0, 0x2018, 0x2019, 0x201c, 0x201d, 0x2022, 0x2013, 0x2014,
+	0x02dc, 0x2122, 0x0161, 0x203a, 0x0153, 0, 0x017e, 0x0178,
+}
+
+var (
+	charsetRe     = regexp.MustCompile(`(?i)charset\s*=\s*["']?([^\s;"'>/]+)`)
+	contentTypeRe = regexp.MustCompile(`(?im)^content-type:.*$`)
+	metaRe        = regexp.MustCompile(`(?i)<meta\s[^>]*>`)
+)
+
+// maxMetaBytes is how far into a page a <meta> charset declaration is looked for.
+const maxMetaBytes = 8 << 10
+
+// contentTypeCharset returns the charset parameter of a Content-Type.
+func contentTypeCharset(contentType string) string {
+	if m := charsetRe.FindStringSubmatch(contentType); m != nil {
+		return strings.ToLower(m[1])
+	}
+	return ""
+}
+
+// HTTPCharset returns the charset declared by the Content-Type in a block of HTTP headers.
+func HTTPCharset(header []byte) string {
+	for _, h := range contentTypeRe.FindAll(header, -1) {
+		if cs := contentTypeCharset(string(h)); len(cs) > 0 {
+			return cs
+		}
+	}
+	return ""
+}
+
+// MetaCharset returns the charset declared by a <meta charset> or <meta http-equiv> tag near the
+// start of an HTML page.
+func MetaCharset(page []byte) string {
+	if len(page) > maxMetaBytes {
+		page = page[:maxMetaBytes]
+	}
+	for _, tag := range metaRe.FindAll(page, -1) {
+		if cs := contentTypeCharset(string(tag)); len(cs) > 0 {
+			return cs
+		}
+	}
+	return ""
+}
+
+// PageCharset returns the charset declared for a web page, which may begin with its HTTP headers,
+// by either the Content-Type header or a <meta> tag.
+func PageCharset(page []byte) string {
+	body := StripHTTPHeader(page)
+	if cs := HTTPCharset(page[:len(page)-len(body)]); len(cs) > 0 {
+		return cs
+	}
+	return MetaCharset(body)
+}
+
+// GuessCharset guesses the charset of text which does not declare one. Text is UTF-8 when at
+// least as many of its non-ASCII characters are valid UTF-8 as are not, and windows-1252
+// otherwise.
+func GuessCharset(b []byte) string {
+	var valid, invalid int
+	for i := 0; i < len(b); {
+		if b[i] < utf8.RuneSelf {
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(b[i:])
+		if r == utf8.RuneError && size == 1 {
+			invalid++
+		} else {
+			valid++
+		}
+		i += size
+	}
+	if invalid > valid {
+		return Windows1252Charset
+	}
+	return UTF8Charset
+}
+
+// TranscodeCounts are the number of bytes which were transcoded to UTF-8, or dropped since they
+// could not be, and the number of documents in a charset which is not supported.
+type TranscodeCounts struct {
+	Transcoded  int64 `json:"transcoded"`
+	Dropped     int64 `json:"dropped"`
+	Unsupported int64 `json:"unsupported"`
+}
+
+// Transcode converts text in a charset to UTF-8, guessing the charset if none is given. It returns
+// the charset the text was decoded as. Bytes of UTF-8 text which are not valid UTF-8 are decoded
+// as windows-1252, since that is how text of mixed encodings usually comes about. Only the valid
+// UTF-8 of text in a charset which is not supported is kept.
+func Transcode(b []byte, charset string) ([]byte, string, TranscodeCounts) {
+	var c TranscodeCounts
+	cs, ok := charsetLabels[strings.ToLower(strings.TrimSpace(charset))]
+	switch {
+	case len(charset) == 0:
+		cs, ok = GuessCharset(b), true
+	case !ok:
+		cs = strings.ToLower(strings.TrimSpace(charset))
+		c.Unsupported = 1
+	}
+	if cs != Windows1252Charset && utf8.Valid(b) {
+		return b, cs, c
+	}
+
+	var (
+		out = make([]byte, 0, len(b)+len(b)/8)
+		buf [utf8.UTFMax]byte
+	)
+	for i := 0; i < len(b); {
+		if b[i] < utf8.RuneSelf {
+			out = append(out, b[i])
+			i++
+			continue
+		}
+		if cs != Windows1252Charset {
+			r, size := utf8.DecodeRune(b[i:])
+			if r != utf8.RuneError || size > 1 {
+				out = append(out, b[i:i+size]...)
+				i += size
+				continue
+			}
+		}
+
+		// A windows-1252 byte.
+		r := rune(b[i])
+		i++
+		if r < 0xa0 {
+			r = cp1252[r-0x80]
+		}
+		if !ok || r == 0 {
+			c.Dropped++
+			continue
+		}
+		n := utf8.EncodeRune(buf[:], r)
+		out = append(out, buf[:n]...)
+		c.Transcoded++
+	}
+	return out, cs, c
+}
+
+// TranscodeLog tallies the bytes transcoded in each collection file. The totals, and the number of
+// documents in each charset which is not supported, are logged, and the counts of each file are
+// written as JSON when Path is set. It is safe for concurrent use.
+type TranscodeLog struct {
+	Path string
+
+	mu          sync.Mutex
+	files       map[string]TranscodeCounts
+	unsupported map[string]int64
+}
+
+// transcoded is where the parsers tally the text they have transcoded.
+var transcoded = new(TranscodeLog)
+
+// Add tallies the bytes transcoded in a document of a file, which was decoded as charset.
+func (t *TranscodeLog) Add(file, charset string, c TranscodeCounts) {
+	if c.Transcoded == 0 && c.Dropped == 0 && c.Unsupported == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.files == nil {
+		t.files = make(map[string]TranscodeCounts)
+		t.unsupported = make(map[string]int64)
+	}
+	f := t.files[file]
+	f.Transcoded += c.Transcoded
+	f.Dropped += c.Dropped
+	f.Unsupported += c.Unsupported
+	t.files[file] = f
+	if c.Unsupported > 0 {
+		t.unsupported[charset] += c.Unsupported
+	}
+}
+
+// Close logs the total number of bytes transcoded, and writes the counts of each file.
+func (t *TranscodeLog) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var total TranscodeCounts
+	for _, c := range t.files {
+		total.Transcoded += c.Transcoded
+		total.Dropped += c.Dropped
+		total.Unsupported += c.Unsupported
+	}
+	if len(t.files) > 0 {
+		log.Printf("transcoded %d bytes to UTF-8 in %d files (%d bytes dropped)\n", total.Transcoded, len(t.files), total.Dropped)
+	}
+	if total.Unsupported > 0 {
+		var charsets []string
+		for cs := range t.unsupported {
+			charsets = append(charsets, cs)
+		}
+		sort.Slice(charsets, func(i, j int) bool {
+			if t.unsupported[charsets[i]] != t.unsupported[charsets[j]] {
+				return t.unsupported[charsets[i]] > t.unsupported[charsets[j]]
+			}
+			return charsets[i] < charsets[j]
+		})
+		for i, cs := range charsets {
+			charsets[i] = fmt.Sprintf("%s (%d)", cs, t.unsupported[cs])
+		}
+		log.Printf("%d documents are in a charset which is not supported, so only their valid UTF-8 was kept: %s\n", total.Unsupported, strings.Join(charsets, ", "))
+	}
+	if len(t.Path) == 0 {
+		return nil
+	}
+
+	files := t.files
+	if files == nil {
+		files = make(map[string]TranscodeCounts)
+	}
+	f, err := os.Create(t.Path)
+	if err != nil {
+		return err
+	}
+	e := json.NewEncoder(f)
+	e.SetIndent("", "  ")
+	err = e.Encode(files)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTranscode(t *testing.T) {
+	var tests = []struct {
+		text     string
+		charset  string
+		expected string
+		decoded  string
+		counts   TranscodeCounts
+	}{
+		{"café", "utf-8", "café", UTF8Charset, TranscodeCounts{}},
+		{"café", "UTF8", "café", UTF8Charset, TranscodeCounts{}},
+		// Stray windows-1252 bytes in UTF-8 text.
+		{"café \x93quoted\x94", "utf-8", "café “quoted”", UTF8Charset, TranscodeCounts{Transcoded: 2}},
+		{"caf\xe9 \x80", "windows-1252", "café €", Windows1252Charset, TranscodeCounts{Transcoded: 2}},
+		{"caf\xe9", " ISO-8859-1 ", "café", Windows1252Charset, TranscodeCounts{Transcoded: 1}},
+		{"caf\xe9", "us-ascii", "café", Windows1252Charset, TranscodeCounts{Transcoded: 1}},
+		// Bytes which are not defined in windows-1252 are dropped.
+		{"a\x81b\x9dc", "cp1252", "abc", Windows1252Charset, TranscodeCounts{Dropped: 2}},
+		// Text which does not declare a charset is guessed.
+		{"café", "", "café", UTF8Charset, TranscodeCounts{}},
+		{"caf\xe9 na\xefve", "", "café naïve", Windows1252Charset, TranscodeCounts{Transcoded: 2}},
+		// Only the valid UTF-8 of a charset which is not supported is kept, and the document counted.
+		{"abc", "Shift_JIS", "abc", "shift_jis", TranscodeCounts{Unsupported: 1}},
+		{"a\x82\xa0b café", "shift_jis", "ab café", "shift_jis", TranscodeCounts{Dropped: 2, Unsupported: 1}},
+	}
+
+	for _, test := range tests {
+		b, cs, c := Transcode([]byte(test.text), test.charset)
+		if string(b) != test.expected || cs != test.decoded || c != test.counts {
+			t.Errorf("Transcode(%q, %q) = %q, %q, %+v, expected %q, %q, %+v", test.text, test.charset, b, cs, c, test.expected, test.decoded, test.counts)
+		}
+	}
+}
+
+func TestPageCharset(t *testing.T) {
+	var tests = []struct {
+		page     string
+		expected string
+	}{
+		{"HTTP/1.1 200 OK\r\nContent-Type: text/html; charset=ISO-8859-1\r\n\r\n<p>page</p>", "iso-8859-1"},
+		{"HTTP/1.1 200 OK\r\nContent-Type: text/html\r\n\r\n<meta charset=\"utf-8\"><p>page</p>", "utf-8"},
+		{"<html><head><meta http-equiv=\"Content-Type\" content=\"text/html; charset=windows-1252\">", "windows-1252"},
+		{"HTTP/1.1 200 OK\r\nContent-Type: text/html; charset='gb2312'\r\n\r\n<meta charset=utf-8>", "gb2312"},
+		{"<p>charset=utf-8 is not declared</p>", ""},
+		{"HTTP/1.1 200 OK\r\nContent-Type: text/html\r\n\r\n<p>page</p>", ""},
+	}
+
+	for _, test := range tests {
+		if cs := PageCharset([]byte(test.page)); cs != test.expected {
+			t.Errorf("PageCharset(%q) = %q, expected %q", test.page, cs, test.expected)
+		}
+	}
+}
+
+func TestTranscodeLog(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	l := &TranscodeLog{Path: filepath.Join(dir, "transcoded.json")}
+	for _, d := range []struct {
+		file, text, charset string
+	}{
+		{"a.gz", "caf\xe9", "latin1"},
+		{"a.gz", "naïve", "utf-8"},
+		{"a.gz", "a\x82\xa0b", "shift_jis"},
+		{"b.gz", "abc", "gb2312"},
+		{"c.gz", "abc", ""},
+	} {
+		_, cs, c := Transcode([]byte(d.text), d.charset)
+		l.Add(d.file, cs, c)
+	}
+	err := l.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(l.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files map[string]TranscodeCounts
+	err = json.Unmarshal(b, &files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]TranscodeCounts{
+		"a.gz": {Transcoded: 1, Dropped: 2, Unsupported: 1},
+		"b.gz": {Unsupported: 1},
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("wrote %+v, expected %+v", files, expected)
+	}
+	if !reflect.DeepEqual(l.unsupported, map[string]int64{"shift_jis": 1, "gb2312": 1}) {
+		t.Errorf("counted %v documents in unsupported charsets, expected shift_jis and gb2312", l.unsupported)
+	}
+}
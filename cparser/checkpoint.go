@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ledger statuses of a collection file.
+const (
+	FileIndexed = "indexed" // Every document was indexed.
+	FilePartial = "partial" // Some documents failed, and were kept to be re-sent.
+)
+
+// LedgerEntry records a collection file whose documents have all been sent to Elasticsearch.
+// Existing counts the indexed documents whose id was already in the index, e.g., duplicates, which
+// replaced a document rather than adding one.
+type LedgerEntry struct {
+	Index    string    `json:"index"`
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	Hash     string    `json:"sha256"`
+	Docs     int       `json:"docs"`
+	Indexed  int       `json:"indexed"`
+	Existing int       `json:"existing"`
+	Failed   int       `json:"failed"`
+	Status   string    `json:"status"`
+	Time     time.Time `json:"time"`
+}
+
+// fileProgress tracks the documents of a file which are still being indexed.
+type fileProgress struct {
+	entry    LedgerEntry
+	parsed   bool
+	sent     int
+	resolved int
+}
+
+// Checkpoint keeps a ledger of the collection files which have been indexed in a state directory,
+// so that an interrupted run can be resumed. A file is only added to the ledger once it has been
+// parsed and Elasticsearch has either indexed or rejected each of its documents. Rejected
+// documents are kept in the state directory so that they can be re-sent. It is safe for
+// concurrent use.
+type Checkpoint struct {
+	Dir   string
+	Index string
+
+	mu       sync.Mutex
+	complete map[string]LedgerEntry
+	progress map[string]*fileProgress
+	ledger   *os.File
+}
+
+// Files in the state directory.
+const (
+	ledgerFile = "ledger.jsonl"
+	failedFile = "failed.jsonl"
+)
+
+// OpenCheckpoint opens (or creates) the state directory of an index, and reads its ledger.
+func OpenCheckpoint(dir, index string) (*Checkpoint, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	c := &Checkpoint{
+		Dir:      dir,
+		Index:    index,
+		complete: make(map[string]LedgerEntry),
+		progress: make(map[string]*fileProgress),
+	}
+	entries, err := ReadLedger(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.Index == index {
+			c.complete[e.Path] = e
+		}
+	}
+	c.ledger, err = os.OpenFile(filepath.Join(dir, ledgerFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ReadLedger reads the entries of the ledger in a state directory. A later entry for a file
+// replaces an earlier one, and a partially written last entry (e.g., after a crash) is ignored.
+func ReadLedger(dir string) ([]LedgerEntry, error) {
+	f, err := os.Open(filepath.Join(dir, ledgerFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		entries []LedgerEntry
+		seen    = make(map[string]int)
+		br      = bufio.NewReader(f)
+	)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var e LedgerEntry
+		if json.Unmarshal(line, &e) != nil {
+			continue
+		}
+		key := e.Index + "\x00" + e.Path
+		if i, ok := seen[key]; ok {
+			entries[i] = e
+			continue
+		}
+		seen[key] = len(entries)
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// FailedPath is the file documents rejected by Elasticsearch are kept in.
+func (c *Checkpoint) FailedPath() string {
+	return filepath.Join(c.Dir, failedFile)
+}
+
+// Completed reports whether a file with the same size and SHA-256 hash is already in the ledger.
+// The file is only hashed if its size matches.
+func (c *Checkpoint) Completed(path string, size int64) (bool, error) {
+	c.mu.Lock()
+	e, ok := c.complete[path]
+	c.mu.Unlock()
+	if !ok || e.Size != size {
+		return false, nil
+	}
+	hash, err := hashFile(path)
+	if err != nil {
+		return false, err
+	}
+	return hash == e.Hash, nil
+}
+
+// hashFile returns the hex-encoded SHA-256 hash of a file.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (c *Checkpoint) file(path string) *fileProgress {
+	p, ok := c.progress[path]
+	if !ok {
+		p = &fileProgress{entry: LedgerEntry{Index: c.Index, Path: path}}
+		c.progress[path] = p
+	}
+	return p
+}
+
+// Parsed records that every document of a file has been parsed.
+func (c *Checkpoint) Parsed(path string, size int64, hash string, docs int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p := c.file(path)
+	p.parsed = true
+	p.entry.Size = size
+	p.entry.Hash = hash
+	p.entry.Docs = docs
+	return c.check(path, p, false)
+}
+
+// Sent records that a document of a file has been added to a bulk request.
+func (c *Checkpoint) Sent(path string) {
+	if len(path) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.file(path).sent++
+}
+
+// Resolved records that Elasticsearch has indexed (or rejected) a document of a file, and whether
+// the index already had a document with the same id. When a document of a file which is already
+// in the ledger, i.e., a re-sent document, is indexed, the file is added to the ledger again with
+// the new counts.
+func (c *Checkpoint) Resolved(path string, indexed, existed bool) error {
+	if len(path) == 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.complete[path]; ok && c.progress[path] == nil {
+		if !indexed {
+			return nil
+		}
+		e.Indexed++
+		e.Failed--
+		if existed {
+			e.Existing++
+		}
+		return c.record(e)
+	}
+	p := c.file(path)
+	p.resolved++
+	if indexed {
+		p.entry.Indexed++
+		if existed {
+			p.entry.Existing++
+		}
+	} else {
+		p.entry.Failed++
+	}
+	return c.check(path, p, false)
+}
+
+// check adds a file to the ledger once each of its documents has been resolved. Unless every
+// document has been sent, e.g., since documents are being held back to remove duplicates, a file
+// is only added once the documents have been flushed.
+func (c *Checkpoint) check(path string, p *fileProgress, flushed bool) error {
+	if !p.parsed || p.resolved < p.sent || (p.sent < p.entry.Docs && !flushed) {
+		return nil
+	}
+	delete(c.progress, path)
+	return c.record(p.entry)
+}
+
+// record adds a file to the ledger.
+func (c *Checkpoint) record(e LedgerEntry) error {
+	e.Status = FileIndexed
+	if e.Failed > 0 {
+		e.Status = FilePartial
+	}
+	e.Time = time.Now().UTC()
+	c.complete[e.Path] = e
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = c.ledger.Write(append(b, '\n'))
+	if err != nil {
+		return err
+	}
+	return c.ledger.Sync()
+}
+
+// Flushed adds the parsed files to the ledger once every document has been sent and resolved.
+func (c *Checkpoint) Flushed() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for path, p := range c.progress {
+		err := c.check(path, p, true)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the ledger.
+func (c *Checkpoint) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ledger.Close()
+}
+
+// ResendFailed sends the documents which were rejected in a previous run again. Documents which
+// are rejected again are kept to be re-sent by the next run. The documents are moved to a file of
+// their own while they are being re-sent, which is only removed once they have all been resolved;
+// if a run stops before then, the next run re-sends them along with any which failed since.
+func ResendFailed(c *Checkpoint, b *BulkIndexer) error {
+	failed := c.FailedPath()
+	resend := failed + ".resend"
+	_, err := os.Stat(resend)
+	switch {
+	case err == nil:
+		log.Printf("re-sending the documents of an interrupted run from %s\n", resend)
+		err = appendFile(resend, failed)
+	case os.IsNotExist(err):
+		err = os.Rename(failed, resend)
+		if os.IsNotExist(err) {
+			return nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadFile(resend)
+	if err != nil {
+		return err
+	}
+	var n int
+	for _, line := range strings.Split(string(data), "\n") {
+		var dl deadLetter
+		if json.Unmarshal([]byte(line), &dl) != nil || len(dl.Action) == 0 {
+			continue
+		}
+		b.Parsed++
+		err = b.add(bulkItem{id: dl.ID, path: dl.Path, action: string(dl.Action), data: dl.Doc})
+		if err != nil {
+			return err
+		}
+		n++
+	}
+	err = b.Flush()
+	if err != nil {
+		return err
+	}
+	log.Printf("re-sent %d documents which failed in a previous run\n", n)
+	return os.Remove(resend)
+}
+
+// appendFile appends the contents of src to dst, then removes src. It does nothing if src does not
+// exist.
+func appendFile(dst, src string) error {
+	in, err := os.Open(src)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// Verify compares the number of documents in an index with the number of documents the ledger
+// records as indexed. Documents whose id was already in the index replaced a document rather than
+// adding one, so the index may have up to that many fewer documents than were indexed, e.g., when
+// a collection has duplicate ids, or when a file was re-sent after an interrupted run. Any other
+// difference is an error.
+func Verify(url, index, dir string) error {
+	entries, err := ReadLedger(dir)
+	if err != nil {
+		return err
+	}
+	var files, docs, indexed, existing, failed int
+	for _, e := range entries {
+		if e.Index != index {
+			continue
+		}
+		files++
+		docs += e.Docs
+		indexed += e.Indexed
+		existing += e.Existing
+		failed += e.Failed
+	}
+
+	url = strings.TrimRight(url, "/")
+	resp, err := http.Post(fmt.Sprintf("%s/%s/_refresh", url, index), "application/json", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	resp, err = http.Get(fmt.Sprintf("%s/%s/_count", url, index))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("counting the documents in %s failed with status %d: %s", index, resp.StatusCode, b)
+	}
+	var count struct {
+		Count int `json:"count"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&count)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("ledger: %d files, %d documents parsed, %d indexed (%d of which replaced one with the same id), %d failed\n", files, docs, indexed, existing, failed)
+	log.Printf("elasticsearch: %d documents in %s\n", count.Count, index)
+	if count.Count > indexed {
+		return fmt.Errorf("%s contains %d documents, but the ledger records %d as indexed", index, count.Count, indexed)
+	}
+	if count.Count < indexed-existing {
+		return fmt.Errorf("%s contains %d documents, but the ledger records %d as indexed with distinct ids, so %d are missing", index, count.Count, indexed-existing, indexed-existing-count.Count)
+	}
+	return nil
+}
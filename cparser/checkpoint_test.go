@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "cparser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestCheckpointCompleted(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "file.txt")
+	if err := ioutil.WriteFile(path, []byte("contents"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := hashFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := OpenCheckpoint(filepath.Join(dir, "state"), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Parsed(path, 8, hash, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range []struct {
+		contents string
+		done     bool
+	}{
+		{"contents", true},
+		{"CONTENTS", false}, // Same size, different hash.
+		{"contents, changed", false},
+	} {
+		if err := ioutil.WriteFile(path, []byte(f.contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		done, err := c.Completed(path, int64(len(f.contents)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if done != f.done {
+			t.Errorf("a file containing %q was completed: %t, expected %t", f.contents, done, f.done)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	ledger := `{"index":"test","path":"a","docs":3,"indexed":3}
+{"index":"test","path":"b","docs":3,"indexed":3,"existing":1}
+{"index":"other","path":"c","docs":7,"indexed":7,"existing":7}
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, ledgerFile), []byte(ledger), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		count int
+		ok    bool
+	}{
+		{6, true},
+		{5, true},  // A document replaced one with the same id.
+		{4, false}, // A document is missing.
+		{7, false},
+	} {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"count":%d}`, c.count)
+		}))
+		err := Verify(s.URL, "test", dir)
+		s.Close()
+		if (err == nil) != c.ok {
+			t.Errorf("verifying an index of %d documents returned %v", c.count, err)
+		}
+	}
+}
+
+// indexFile indexes documents as the documents of a file, with a checkpoint.
+func indexFile(t *testing.T, c *Checkpoint, b *BulkIndexer, path string, ids ...string) {
+	w := pathWriter{path: path, w: b}
+	for _, id := range ids {
+		if err := w.Write(Document{ID: id, Fields: map[string]interface{}{"text": id}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Parsed(path, 0, "", len(ids)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckpointCountsExisting(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	s := newBulkServer(t, nil)
+	defer s.Close()
+
+	c, err := OpenCheckpoint(dir, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := testIndexer(s)
+	b.Checkpoint = c
+	b.MaxDocs = 2
+	indexFile(t, c, b, "a", "d0", "d1", "d0")
+	indexFile(t, c, b, "b", "d1", "d2")
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ReadLedger(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("%d ledger entries, expected 2", len(entries))
+	}
+	for i, expected := range []struct{ indexed, existing int }{{3, 1}, {2, 1}} {
+		if e := entries[i]; e.Indexed != expected.indexed || e.Existing != expected.existing {
+			t.Errorf("%s: %d indexed (%d existing), expected %d (%d)", e.Path, e.Indexed, e.Existing, expected.indexed, expected.existing)
+		}
+	}
+}
+
+func TestResendFailedInterrupted(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	s := newBulkServer(t, nil)
+	defer s.Close()
+
+	c, err := OpenCheckpoint(dir, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	letter := func(id string) string {
+		return fmt.Sprintf(`{"id":%q,"status":400,"action":{"index":{"_index":"test","_id":%q}},"doc":{}}`+"\n", id, id)
+	}
+	// A previous run stopped while re-sending d0 and d1, then d2 failed in the run after it.
+	files := map[string]string{
+		c.FailedPath() + ".resend": letter("d0") + letter("d1"),
+		c.FailedPath():             letter("d2"),
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b := testIndexer(s)
+	b.Checkpoint = c
+	b.DeadLetter = c.FailedPath()
+	if err := ResendFailed(c, b); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.requests) != 1 || fmt.Sprint(s.requests[0]) != "[d0 d1 d2]" {
+		t.Errorf("re-sent %v, expected [[d0 d1 d2]]", s.requests)
+	}
+	for name := range files {
+		if _, err := os.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("%s was not removed", filepath.Base(name))
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Magic bytes of the compression and archive formats found in collections.
+var (
+	gzipMagic     = []byte{0x1f, 0x8b}
+	compressMagic = []byte{0x1f, 0x9d}
+	bzip2Magic    = []byte("BZh")
+	tarMagic      = []byte("ustar")
+)
+
+// tarMagicOffset is the position of the magic bytes in a tar header.
+const tarMagicOffset = 257
+
+// compressionExts are the file extensions removed from the name of a decompressed file.
+var compressionExts = []string{".gz", ".tgz", ".bz2", ".z", ".0z", ".1z", ".2z", ".tar"}
+
+// trimCompressionExt removes a compression extension from a file name.
+func trimCompressionExt(name string) string {
+	lower := strings.ToLower(name)
+	for _, ext := range compressionExts {
+		if strings.HasSuffix(lower, ext) {
+			return name[:len(name)-len(ext)]
+		}
+	}
+	return name
+}
+
+// Decompress detects the compression of r by its magic bytes and calls fn with the decompressed
+// contents. Unix compress (LZW), gzip and bzip2 streams are decompressed, and every regular file
+// in a tar archive is passed to fn separately. Nested formats, such as a gzipped tar, are handled
+// recursively. Data that is not compressed is passed to fn unchanged.
+func Decompress(name string, r io.Reader, fn func(name string, r io.Reader) error) error {
+	br := bufio.NewReaderSize(r, 64*1024)
+	magic, err := br.Peek(tarMagicOffset + len(tarMagic))
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		defer zr.Close()
+		return Decompress(trimCompressionExt(name), zr, fn)
+	case bytes.HasPrefix(magic, compressMagic):
+		zr, err := newLZWReader(br)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		return Decompress(trimCompressionExt(name), zr, fn)
+	case bytes.HasPrefix(magic, bzip2Magic) && len(magic) > 3 && magic[3] >= '1' && magic[3] <= '9':
+		return Decompress(trimCompressionExt(name), bzip2.NewReader(br), fn)
+	case len(magic) >= tarMagicOffset+len(tarMagic) && bytes.Equal(magic[tarMagicOffset:], tarMagic):
+		tr := tar.NewReader(br)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+				continue
+			}
+			err = Decompress(path.Join(name, hdr.Name), tr, fn)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return fn(name, br)
+}
+
+// ParseCompressed decompresses r and parses each of the files it contains. When the format is
+// auto, the parser is picked using the name of each decompressed file.
+func ParseCompressed(name string, r io.Reader, format CollectionFormat, w DocumentWriter) (int, error) {
+	// Some formats (e.g., WARC files, which are compressed per record) handle compression themselves.
+	f := format
+	if f == Auto {
+		f = FormatForFile(name)
+	}
+	if c, err := FormatFor(f); err == nil && c.Compressed {
+		return c.Parse(name, r, w)
+	}
+
+	var n int
+	err := Decompress(name, r, func(name string, r io.Reader) error {
+		f := format
+		if f == Auto {
+			f = FormatForFile(name)
+		}
+		c, err := FormatFor(f)
+		if err != nil {
+			return err
+		}
+		m, err := c.Parse(name, r, w)
+		n += m
+		return err
+	})
+	return n, err
+}
+
+// lzwReader decompresses the output of the Unix compress utility (.Z files). The standard library
+// lzw package cannot be used, since compress uses a variable code width with a block clear code,
+// and pads the input to a multiple of the code width whenever the width changes.
+type lzwReader struct {
+	r       *bufio.Reader
+	maxBits uint
+	block   bool
+
+	bits uint   // Current code width.
+	mask int    // Largest code of the current width.
+	end  int    // Last code in the table.
+	buf  uint32 // Bits read but not yet used.
+	left uint   // Number of bits in buf.
+	n    int    // Bytes read since the code width last changed.
+
+	prev, final int
+	started     bool
+
+	prefix []uint16
+	suffix []byte
+	stack  []byte
+	out    []byte
+	pos    int
+	err    error
+}
+
+var errLZWCode = errors.New("compress: invalid code")
+
+func newLZWReader(r *bufio.Reader) (*lzwReader, error) {
+	header := make([]byte, 3)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(header, compressMagic) {
+		return nil, errors.New("compress: invalid header")
+	}
+	l := &lzwReader{
+		r:       r,
+		maxBits: uint(header[2] & 0x1f),
+		block:   header[2]&0x80 != 0,
+		bits:    9,
+		mask:    0x1ff,
+		end:     255,
+		prefix:  make([]uint16, 1<<16),
+		suffix:  make([]byte, 1<<16),
+	}
+	if l.maxBits < 9 || l.maxBits > 16 {
+		return nil, fmt.Errorf("compress: invalid maximum code width %d", l.maxBits)
+	}
+	if l.block {
+		l.end = 256
+	}
+	return l, nil
+}
+
+func (l *lzwReader) readByte() (byte, error) {
+	b, err := l.r.ReadByte()
+	if err == nil {
+		l.n++
+	}
+	return b, err
+}
+
+// skip discards the remainder of the current group of codes. Compress reads and writes codes in
+// groups of eight, so a change in code width always begins on a group boundary.
+func (l *lzwReader) skip() error {
+	if rem := l.n % int(l.bits); rem != 0 {
+		for i := 0; i < int(l.bits)-rem; i++ {
+			if _, err := l.readByte(); err != nil {
+				return err
+			}
+		}
+	}
+	l.buf = 0
+	l.left = 0
+	l.n = 0
+	return nil
+}
+
+func (l *lzwReader) code() (int, error) {
+	if l.end >= l.mask && l.bits < l.maxBits {
+		if err := l.skip(); err != nil {
+			return 0, err
+		}
+		l.bits++
+		l.mask = l.mask<<1 | 1
+	}
+	for l.left < l.bits {
+		// Any bits left over at the end of the input are padding.
+		b, err := l.readByte()
+		if err != nil {
+			return 0, err
+		}
+		l.buf |= uint32(b) << l.left
+		l.left += 8
+	}
+	code := int(l.buf & uint32(l.mask))
+	l.buf >>= l.bits
+	l.left -= l.bits
+	return code, nil
+}
+
+// decode decompresses the next code into the output buffer.
+func (l *lzwReader) decode() error {
+	code, err := l.code()
+	if err != nil {
+		return err
+	}
+
+	// The first code is always a literal, and does not create a table entry.
+	if !l.started {
+		if code > 255 {
+			return errLZWCode
+		}
+		l.started = true
+		l.prev, l.final = code, code
+		l.out = append(l.out, byte(code))
+		return nil
+	}
+
+	if code == 256 && l.block {
+		if err := l.skip(); err != nil {
+			return err
+		}
+		l.bits = 9
+		l.mask = 0x1ff
+		l.end = 255
+		return nil
+	}
+
+	temp := code
+	l.stack = l.stack[:0]
+	if code > l.end {
+		// The code is the one about to be created, i.e., the previous string and its first byte.
+		if code != l.end+1 || l.prev > l.end {
+			return errLZWCode
+		}
+		l.stack = append(l.stack, byte(l.final))
+		code = l.prev
+	}
+	for code >= 256 {
+		l.stack = append(l.stack, l.suffix[code])
+		code = int(l.prefix[code])
+	}
+	l.stack = append(l.stack, byte(code))
+	l.final = code
+
+	if l.end < l.mask {
+		l.end++
+		l.prefix[l.end] = uint16(l.prev)
+		l.suffix[l.end] = byte(l.final)
+	}
+	l.prev = temp
+
+	for i := len(l.stack) - 1; i >= 0; i-- {
+		l.out = append(l.out, l.stack[i])
+	}
+	return nil
+}
+
+func (l *lzwReader) Read(p []byte) (int, error) {
+	for l.pos == len(l.out) {
+		if l.err != nil {
+			return 0, l.err
+		}
+		l.out = l.out[:0]
+		l.pos = 0
+		l.err = l.decode()
+	}
+	n := copy(p, l.out[l.pos:])
+	l.pos += n
+	return n, nil
+}
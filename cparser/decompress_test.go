@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// decompressFile decompresses a file, returning the name and contents of each file within it.
+func decompressFile(name string, data []byte) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	err := Decompress(name, bytes.NewReader(data), func(name string, r io.Reader) error {
+		b, err := ioutil.ReadAll(r)
+		files[name] = b
+		return err
+	})
+	return files, err
+}
+
+// readTestdata reads a compressed test file, or the text it decompresses to.
+func readTestdata(t *testing.T, name string) []byte {
+	b, err := ioutil.ReadFile(filepath.Join("testdata", "compress", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestLZW(t *testing.T) {
+	expected := readTestdata(t, "words.txt")
+	for _, name := range []string{
+		"words12.Z", // Codes of up to 12 bits.
+		"words16.Z", // Codes of up to 16 bits.
+		"clear10.Z", // Codes of up to 10 bits, with the table cleared each time it fills.
+	} {
+		files, err := decompressFile(name, readTestdata(t, name))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(files[name[:len(name)-len(".Z")]], expected) {
+			t.Errorf("%s was not decompressed to words.txt", name)
+		}
+	}
+}
+
+func TestLZWEmpty(t *testing.T) {
+	files, err := decompressFile("empty.Z", readTestdata(t, "empty.Z"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, ok := files["empty"]; !ok || len(b) != 0 {
+		t.Errorf("empty.Z was decompressed to %q", b)
+	}
+}
+
+func TestLZWTruncated(t *testing.T) {
+	// A file which ends in the header cannot be decompressed.
+	if _, err := decompressFile("header.Z", []byte{0x1f, 0x9d}); err == nil {
+		t.Error("a truncated header was decompressed")
+	}
+
+	// A file which ends part way through is decompressed up to where it ends.
+	data := readTestdata(t, "words12.Z")
+	expected := readTestdata(t, "words.txt")
+	files, err := decompressFile("words12.Z", data[:len(data)/2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := files["words12"]
+	if len(b) == 0 || len(b) >= len(expected) || !bytes.HasPrefix(expected, b) {
+		t.Errorf("the first half of words12.Z was decompressed to %d bytes, which are not the start of words.txt", len(b))
+	}
+}
+
+func TestLZWInvalidCode(t *testing.T) {
+	// The first code must be a literal byte, but this one is 300.
+	_, err := decompressFile("bad.Z", []byte{0x1f, 0x9d, 0x90, 0x2c, 0x01})
+	if err != errLZWCode {
+		t.Errorf("decompressing an invalid code returned %v, expected %v", err, errLZWCode)
+	}
+}
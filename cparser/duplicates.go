@@ -0,0 +1,324 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// DuplicatePolicy decides which copy of a duplicated Washington Post article is indexed.
+type DuplicatePolicy string
+
+const (
+	FirstWins  DuplicatePolicy = "first"  // The copy seen first is indexed.
+	LatestWins DuplicatePolicy = "latest" // The copy published most recently is indexed.
+	Merge      DuplicatePolicy = "merge"  // The copies are merged into the one published most recently.
+)
+
+// Reasons articles are considered duplicates.
+const (
+	duplicateID   = "id"
+	duplicateBody = "exact"
+	duplicateNear = "near"
+)
+
+// Near-duplicate detection parameters.
+const (
+	nearDuplicateShingle  = 3  // Number of words in a shingle.
+	nearDuplicateDistance = 3  // Maximum number of bits fingerprints can differ by.
+	nearDuplicateMinWords = 20 // Shorter articles are only compared by id.
+)
+
+// ParseDuplicatePolicy checks that a duplicate policy is known.
+func ParseDuplicatePolicy(s string) (DuplicatePolicy, error) {
+	switch p := DuplicatePolicy(s); p {
+	case FirstWins, LatestWins, Merge:
+		return p, nil
+	}
+	return "", fmt.Errorf("%s is not a known duplicate policy (first, latest or merge)", s)
+}
+
+// waPostEntry is what is remembered about each article while looking for duplicates. The article
+// itself is spooled to a temporary file.
+type waPostEntry struct {
+	id        string
+	metadata  map[string]string
+	published string
+	offset    int64
+	length    int
+	parent    int
+	reasons   map[string]bool
+}
+
+// duplicateReport is written for each group of articles collapsed into one.
+type duplicateReport struct {
+	Kept      string   `json:"kept"`
+	Collapsed []string `json:"collapsed"`
+	Reasons   []string `json:"reasons"`
+}
+
+// WaPostDeduplicator finds Washington Post articles which share an id, have identical bodies, or
+// have near-identical bodies, and writes a single article for each group to W according to the
+// policy. Since the latest copy of an article may be the last one seen, articles are only written
+// once the deduplicator is closed. A report of the collapsed ids is written to Report, if set.
+type WaPostDeduplicator struct {
+	Policy DuplicatePolicy
+	Report string
+	W      DocumentWriter
+
+	spool   *os.File
+	offset  int64
+	entries []waPostEntry
+	ids     map[string]int
+	bodies  map[[sha256.Size]byte]int // Keyed by hash, so the bodies are not kept in memory.
+	bands   map[uint64][]int
+	prints  []uint64
+}
+
+// find returns the first article of the group an article belongs to.
+func (d *WaPostDeduplicator) find(i int) int {
+	for d.entries[i].parent != i {
+		d.entries[i].parent = d.entries[d.entries[i].parent].parent
+		i = d.entries[i].parent
+	}
+	return i
+}
+
+// union puts two articles in the same group, recording why.
+func (d *WaPostDeduplicator) union(i, j int, reason string) {
+	a, b := d.find(i), d.find(j)
+	if a > b {
+		a, b = b, a
+	}
+	d.entries[b].parent = a
+	if d.entries[a].reasons == nil {
+		d.entries[a].reasons = make(map[string]bool)
+	}
+	d.entries[a].reasons[reason] = true
+	for r := range d.entries[b].reasons {
+		d.entries[a].reasons[r] = true
+	}
+}
+
+func (d *WaPostDeduplicator) Write(doc Document) error {
+	if d.spool == nil {
+		f, err := ioutil.TempFile("", "cparser-wapo")
+		if err != nil {
+			return err
+		}
+		d.spool = f
+		d.ids = make(map[string]int)
+		d.bodies = make(map[[sha256.Size]byte]int)
+		d.bands = make(map[uint64][]int)
+	}
+
+	data, err := doc.Source()
+	if err != nil {
+		return err
+	}
+	var a WaPostDocument
+	err = json.Unmarshal(data, &a)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.spool.Write(data)
+	if err != nil {
+		return err
+	}
+	i := len(d.entries)
+	d.entries = append(d.entries, waPostEntry{
+		id:        doc.ID,
+		metadata:  doc.Metadata,
+		published: a.PublishedDate,
+		offset:    d.offset,
+		length:    len(data),
+		parent:    i,
+	})
+	d.offset += int64(len(data))
+
+	if j, ok := d.ids[doc.ID]; ok {
+		d.union(j, i, duplicateID)
+	} else {
+		d.ids[doc.ID] = i
+	}
+
+	// Short articles (e.g., photo galleries) often share their text without being duplicates.
+	words := Words(a.Text)
+	f := SimHash(words, nearDuplicateShingle)
+	d.prints = append(d.prints, f)
+	if len(words) < nearDuplicateMinWords {
+		return nil
+	}
+
+	body := sha256.Sum256([]byte(strings.Join(words, " ")))
+	if j, ok := d.bodies[body]; ok {
+		d.union(j, i, duplicateBody)
+	} else {
+		d.bodies[body] = i
+	}
+
+	for b := 0; b < SimHashBands; b++ {
+		band := SimHashBand(f, b)
+		for _, j := range d.bands[band] {
+			if d.find(j) != d.find(i) && HammingDistance(d.prints[j], f) <= nearDuplicateDistance {
+				d.union(j, i, duplicateNear)
+			}
+		}
+		d.bands[band] = append(d.bands[band], i)
+	}
+	return nil
+}
+
+// read reads an article back from the spool file.
+func (d *WaPostDeduplicator) read(i int) (WaPostDocument, []byte, error) {
+	var doc WaPostDocument
+	b := make([]byte, d.entries[i].length)
+	_, err := d.spool.ReadAt(b, d.entries[i].offset)
+	if err != nil && err != io.EOF {
+		return doc, nil, err
+	}
+	err = json.Unmarshal(b, &doc)
+	return doc, b, err
+}
+
+// resolve picks the article to index for a group, merging the group if required. It returns the
+// position of the kept article and the document to write.
+func (d *WaPostDeduplicator) resolve(group []int) (int, []byte, error) {
+	kept := group[0]
+	if d.Policy == LatestWins || d.Policy == Merge {
+		for _, i := range group[1:] {
+			// Dates are RFC 3339 in UTC, so they can be compared as strings.
+			if d.entries[i].published > d.entries[kept].published {
+				kept = i
+			}
+		}
+	}
+	doc, data, err := d.read(kept)
+	if err != nil {
+		return kept, nil, err
+	}
+	if d.Policy != Merge || len(group) == 1 {
+		return kept, data, nil
+	}
+
+	// Fill in anything missing from the kept article using the other copies.
+	captions := make(map[string]bool)
+	for _, c := range doc.Captions {
+		captions[c] = true
+	}
+	for _, i := range group {
+		if i == kept {
+			continue
+		}
+		other, _, err := d.read(i)
+		if err != nil {
+			return kept, nil, err
+		}
+		for _, f := range []struct{ kept, other *string }{
+			{&doc.ArticleURL, &other.ArticleURL},
+			{&doc.Title, &other.Title},
+			{&doc.Author, &other.Author},
+			{&doc.PublishedDate, &other.PublishedDate},
+			{&doc.Type, &other.Type},
+			{&doc.Source, &other.Source},
+		} {
+			if len(*f.kept) == 0 {
+				*f.kept = *f.other
+			}
+		}
+		if len(other.Text) > len(doc.Text) {
+			doc.Text = other.Text
+		}
+		for _, c := range other.Captions {
+			if !captions[c] {
+				captions[c] = true
+				doc.Captions = append(doc.Captions, c)
+			}
+		}
+		if other.Id != doc.Id {
+			doc.DuplicateIds = append(doc.DuplicateIds, other.Id)
+		}
+	}
+	data, err = json.Marshal(doc)
+	if err != nil {
+		return kept, nil, err
+	}
+	return kept, data, nil
+}
+
+// Close writes one article for each group of duplicates, then closes W.
+func (d *WaPostDeduplicator) Close() error {
+	if d.spool == nil {
+		return d.W.Close()
+	}
+	defer os.Remove(d.spool.Name())
+	defer d.spool.Close()
+
+	var report *json.Encoder
+	if len(d.Report) > 0 {
+		f, err := os.Create(d.Report)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		report = json.NewEncoder(f)
+	}
+
+	// Groups are written in the order their first article was seen.
+	groups := make(map[int][]int)
+	var roots []int
+	for i := range d.entries {
+		root := d.find(i)
+		if _, ok := groups[root]; !ok {
+			roots = append(roots, root)
+		}
+		groups[root] = append(groups[root], i)
+	}
+
+	collapsed := 0
+	for _, root := range roots {
+		group := groups[root]
+		kept, data, err := d.resolve(group)
+		if err != nil {
+			return err
+		}
+		id := d.entries[kept].id
+		fields, err := decodeFields(data)
+		if err != nil {
+			return err
+		}
+		err = d.W.Write(Document{ID: id, Fields: fields, Metadata: d.entries[kept].metadata})
+		if err != nil {
+			return err
+		}
+		if len(group) == 1 {
+			continue
+		}
+		collapsed += len(group) - 1
+		if report != nil {
+			r := duplicateReport{Kept: id}
+			for _, i := range group {
+				if i != kept {
+					r.Collapsed = append(r.Collapsed, d.entries[i].id)
+				}
+			}
+			for reason := range d.entries[root].reasons {
+				r.Reasons = append(r.Reasons, reason)
+			}
+			sort.Strings(r.Reasons)
+			err = report.Encode(r)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	log.Printf("collapsed %d duplicate articles, writing %d articles (%s policy)\n", collapsed, len(roots), d.Policy)
+	return d.W.Close()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// documentCollector keeps the documents written to it.
+type documentCollector struct {
+	docs   []Document
+	closed bool
+}
+
+func (c *documentCollector) Write(d Document) error {
+	c.docs = append(c.docs, d)
+	return nil
+}
+
+func (c *documentCollector) Close() error {
+	c.closed = true
+	return nil
+}
+
+func waPostDoc(t *testing.T, id, published, text string) Document {
+	d, err := NewDocument(id, WaPostDocument{Id: id, PublishedDate: published, Text: text})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestWaPostDeduplicator(t *testing.T) {
+	var words []string
+	for i := 0; i < 40; i++ {
+		words = append(words, fmt.Sprintf("word%d", i))
+	}
+	body := strings.Join(words, " ")
+	other := strings.Replace(body, "word", "other", -1)
+
+	c := new(documentCollector)
+	d := &WaPostDeduplicator{Policy: LatestWins, W: c}
+	for _, doc := range []Document{
+		waPostDoc(t, "a", "2017-01-01T00:00:00Z", body),
+		waPostDoc(t, "b", "2017-01-02T00:00:00Z", other),
+		waPostDoc(t, "c", "2017-01-03T00:00:00Z", "  "+strings.Join(words, "\n")), // Same words as a.
+		waPostDoc(t, "b", "2017-01-01T00:00:00Z", other),
+		waPostDoc(t, "d", "2017-01-04T00:00:00Z", "short"),
+		waPostDoc(t, "e", "2017-01-05T00:00:00Z", "short"), // Too short to be compared by body.
+	} {
+		if err := d.Write(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for _, doc := range c.docs {
+		ids = append(ids, doc.ID)
+	}
+	if got := strings.Join(ids, ","); got != "c,b,d,e" {
+		t.Errorf("wrote %s, expected c,b,d,e", got)
+	}
+	if !c.closed {
+		t.Error("the writer was not closed")
+	}
+}
+
+func TestWaPostDeduplicatorMerge(t *testing.T) {
+	full := WaPostDocument{
+		Id:            "a",
+		ArticleURL:    "https://www.washingtonpost.com/a",
+		Title:         "Title",
+		Author:        "Author",
+		PublishedDate: "2017-01-01T00:00:00Z",
+		Type:          "article",
+		Source:        "The Washington Post",
+		Text:          "short text",
+		Captions:      []string{"one"},
+	}
+	// The latest copy is missing everything but its id, date and text.
+	latest := WaPostDocument{Id: "a", PublishedDate: "2017-01-02T00:00:00Z", Text: "the longer text of the article", Captions: []string{"two"}}
+
+	c := new(documentCollector)
+	d := &WaPostDeduplicator{Policy: Merge, W: c}
+	for _, a := range []WaPostDocument{full, latest} {
+		doc, err := NewDocument(a.Id, a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Write(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.docs) != 1 {
+		t.Fatalf("wrote %d articles, expected 1", len(c.docs))
+	}
+
+	// Every field of the merged article is filled in.
+	f := c.docs[0].Fields
+	for _, field := range []string{"id", "article_url", "title", "author", "published_date", "type", "source", "text", "captions"} {
+		if v, ok := f[field]; !ok || v == "" {
+			t.Errorf("the merged article has no %s", field)
+		}
+	}
+	if f["published_date"] != latest.PublishedDate || f["text"] != latest.Text || f["source"] != full.Source {
+		t.Errorf("merged article %v", f)
+	}
+	if captions, _ := f["captions"].([]interface{}); len(captions) != 2 {
+		t.Errorf("merged captions %v, expected two and one", f["captions"])
+	}
+}
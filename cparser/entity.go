@@ -0,0 +1,219 @@
+package main
+
+import (
+	"html"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// SGMLEntities are the entities used in the TREC collections which are not part of HTML, e.g.,
+// the ISO 8879 entities used by FBIS and FR94 on TREC disks 4 and 5.
+var SGMLEntities = map[string]string{
+	"hyph":   "-",
+	"blank":  " ",
+	"space":  " ",
+	"lsqb":   "[",
+	"rsqb":   "]",
+	"lcub":   "{",
+	"rcub":   "}",
+	"lpar":   "(",
+	"rpar":   ")",
+	"verbar": "|",
+	"equals": "=",
+	"plus":   "+",
+	"percnt": "%",
+	"dollar": "$",
+	"num":    "#",
+	"ast":    "*",
+	"commat": "@",
+	"excl":   "!",
+	"quest":  "?",
+	"sol":    "/",
+	"bsol":   "\\",
+	"lowbar": "_",
+	"colon":  ":",
+	"semi":   ";",
+	"comma":  ",",
+	"period": ".",
+	"grave":  "`",
+	"tilde":  "~",
+	"circ":   "^",
+	"dash":   "-",
+	"half":   "½",
+	"frac13": "⅓",
+	"frac23": "⅔",
+	"frac18": "⅛",
+	"frac38": "⅜",
+	"frac58": "⅝",
+	"frac78": "⅞",
+	"racute": "ŕ",
+	"Racute": "Ŕ",
+	"cacute": "ć",
+	"Cacute": "Ć",
+	"ncaron": "ň",
+	"scaron": "š",
+	"Scaron": "Š",
+	"zcaron": "ž",
+	"Zcaron": "Ž",
+	"ccaron": "č",
+	"Ccaron": "Č",
+	"rcaron": "ř",
+	"ecaron": "ě",
+	"gbreve": "ğ",
+	"scedil": "ş",
+	"lstrok": "ł",
+	"Lstrok": "Ł",
+	"inodot": "ı",
+	"squ":    "□",
+	"cir":    "○",
+	"male":   "♂",
+	"female": "♀",
+	"check":  "✓",
+	"cross":  "✗",
+}
+
+// maxEntityLen is the length of the longest entity name that will be decoded.
+const maxEntityLen = 32
+
+// EntityDecoder replaces SGML and HTML entities with the text they represent. The decoded text is
+// escaped so it can still be decoded as XML. Unknown entities are removed, and counted when
+// Report is set. It is safe for concurrent use.
+type EntityDecoder struct {
+	Report bool
+
+	mu      sync.Mutex
+	unknown map[string]int
+}
+
+// entities decodes the entities in collection files.
+var entities = new(EntityDecoder)
+
+// Decode replaces the entities in s with the text they represent.
+func (e *EntityDecoder) Decode(s string) string {
+	if strings.IndexByte(s, '&') < 0 {
+		return s
+	}
+
+	var b strings.Builder
+	b.Grow(len(s))
+	for {
+		i := strings.IndexByte(s, '&')
+		if i < 0 {
+			b.WriteString(s)
+			break
+		}
+		b.WriteString(s[:i])
+		s = s[i:]
+
+		name, ok := entityName(s)
+		if !ok {
+			// A bare ampersand.
+			b.WriteString("&amp;")
+			s = s[1:]
+			continue
+		}
+		s = s[len(name)+2:]
+
+		text, ok := e.lookup(name)
+		if !ok {
+			e.count(name)
+			continue
+		}
+		writeXMLText(&b, text)
+	}
+	return b.String()
+}
+
+// entityName returns the name of the entity at the start of s, if there is one.
+func entityName(s string) (string, bool) {
+	for i := 1; i < len(s) && i <= maxEntityLen+1; i++ {
+		c := s[i]
+		switch {
+		case c == ';':
+			return s[1:i], i > 1
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '#' && i == 1:
+		default:
+			return "", false
+		}
+	}
+	return "", false
+}
+
+func (e *EntityDecoder) lookup(name string) (string, bool) {
+	if name[0] == '#' {
+		var (
+			n   uint64
+			err error
+		)
+		if len(name) > 1 && (name[1] == 'x' || name[1] == 'X') {
+			n, err = strconv.ParseUint(name[2:], 16, 32)
+		} else {
+			n, err = strconv.ParseUint(name[1:], 10, 32)
+		}
+		if err != nil || !utf8.ValidRune(rune(n)) {
+			return "", false
+		}
+		return string(rune(n)), true
+	}
+	if text, ok := SGMLEntities[name]; ok {
+		return text, true
+	}
+	entity := "&" + name + ";"
+	if text := html.UnescapeString(entity); text != entity {
+		return text, true
+	}
+	return "", false
+}
+
+func (e *EntityDecoder) count(name string) {
+	if !e.Report {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.unknown == nil {
+		e.unknown = make(map[string]int)
+	}
+	e.unknown[name]++
+}
+
+// LogUnknown logs the unknown entities which were removed, most frequent first.
+func (e *EntityDecoder) LogUnknown() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	names := make([]string, 0, len(e.unknown))
+	for name := range e.unknown {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if e.unknown[names[i]] == e.unknown[names[j]] {
+			return names[i] < names[j]
+		}
+		return e.unknown[names[i]] > e.unknown[names[j]]
+	})
+	for _, name := range names {
+		log.Printf("unknown entity &%s; removed %d times\n", name, e.unknown[name])
+	}
+}
+
+// writeXMLText writes text, escaping the characters which are significant in XML and removing
+// those which are not allowed in XML.
+func writeXMLText(b *strings.Builder, text string) {
+	for _, r := range text {
+		switch {
+		case r == '<':
+			b.WriteString("&lt;")
+		case r == '>':
+			b.WriteString("&gt;")
+		case r == '&':
+			b.WriteString("&amp;")
+		case r < 0x20 && r != '\t' && r != '\n' && r != '\r':
+		default:
+			b.WriteRune(r)
+		}
+	}
+}
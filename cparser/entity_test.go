@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+func TestEntityDecoderDecode(t *testing.T) {
+	for _, c := range []struct {
+		in, out string
+	}{
+		{"no entities", "no entities"},
+		{"AT&T and R&D", "AT&amp;T and R&amp;D"},
+		{"a & b", "a &amp; b"},
+		{"&", "&amp;"},
+		{"&;", "&amp;;"},
+		{"&hyph; &lsqb;x&rsqb; &blank;", "- [x]  "},
+		{"caf&eacute; &amp; &quot;bar&quot;", `café &amp; "bar"`},
+		{"&#65;&#x42;&#X43;", "ABC"},
+		{"&#60;tag&#62;", "&lt;tag&gt;"},
+		{"&lt;P&gt;", "&lt;P&gt;"},
+		{"&#1;", ""},             // Not allowed in XML.
+		{"&#xD800;", ""},         // Not a character.
+		{"&#99999999999;", ""},   // Out of range.
+		{"x&unknownent;y", "xy"}, // Unknown entities are removed.
+		{"&a-b;", "&amp;a-b;"},   // Not an entity name.
+	} {
+		e := new(EntityDecoder)
+		if out := e.Decode(c.in); out != c.out {
+			t.Errorf("Decode(%q) = %q, expected %q", c.in, out, c.out)
+		}
+	}
+}
+
+func TestEntityDecoderCountsUnknown(t *testing.T) {
+	e := &EntityDecoder{Report: true}
+	e.Decode("&foo; &bar; &foo; &hyph;")
+	if e.unknown["foo"] != 2 || e.unknown["bar"] != 1 || len(e.unknown) != 2 {
+		t.Errorf("counted unknown entities %v, expected foo 2 and bar 1", e.unknown)
+	}
+}
+
+func TestEntityDecoderOutputIsXML(t *testing.T) {
+	in := "<DOC><TEXT>AT&T &lsqb;&amp;&rsqb; &#60;b&#62; &#x26; &eacute; & &unknown; &#1;</TEXT></DOC>"
+	var d struct {
+		Text string `xml:"TEXT"`
+	}
+	err := xml.Unmarshal([]byte(new(EntityDecoder).Decode(in)), &d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "AT&T [&] <b> & é &  "; d.Text != expected {
+		t.Errorf("decoded %q, expected %q", d.Text, expected)
+	}
+}
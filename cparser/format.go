@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Document is a parsed document, whatever the format of its collection. Fields are the source of
+// the indexed document, and Metadata describes where the document came from (e.g., its file and
+// byte offset); metadata is not indexed.
+type Document struct {
+	ID       string
+	Fields   map[string]interface{}
+	Metadata map[string]string
+}
+
+// NewDocument creates a document from a map of fields, or from a struct which is encoded to JSON.
+func NewDocument(id string, v interface{}) (Document, error) {
+	if fields, ok := v.(map[string]interface{}); ok {
+		return Document{ID: id, Fields: fields}, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return Document{}, err
+	}
+	fields, err := decodeFields(b)
+	return Document{ID: id, Fields: fields}, err
+}
+
+// decodeFields decodes the fields of a JSON document, keeping numbers as they were written.
+func decodeFields(b []byte) (map[string]interface{}, error) {
+	var fields map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	err := d.Decode(&fields)
+	return fields, err
+}
+
+// Source encodes the fields of the document as JSON.
+func (d Document) Source() ([]byte, error) {
+	return json.Marshal(d.Fields)
+}
+
+// SetMetadata records where the document came from.
+func (d *Document) SetMetadata(key, value string) {
+	if d.Metadata == nil {
+		d.Metadata = make(map[string]string)
+	}
+	d.Metadata[key] = value
+}
+
+// CollectionParser parses every document in a collection file, writing them to w. It returns the
+// number of documents written. The name of the file is used to report malformed documents.
+type CollectionParser func(name string, r io.Reader, w DocumentWriter) (int, error)
+
+// Format is a collection format which can be parsed.
+type Format struct {
+	Name        CollectionFormat
+	Description string
+	Parse       CollectionParser
+
+	// Extensions are the file extensions the auto format picks this format for.
+	Extensions []string
+
+	// Fields are how the fields of documents are indexed, for those which are not text.
+	Fields map[string]FieldKind
+
+	// Split, if set, finds the end of the last whole document in a chunk of a stream, so that a
+	// stream can be cut into chunks which are parsed concurrently. It returns 0 if there is none.
+	Split func(data []byte) int
+
+	// Compressed is set when the parser handles compressed files itself, e.g., WARC files, which
+	// are compressed per record.
+	Compressed bool
+}
+
+// formats are the registered collection formats.
+var formats = make(map[CollectionFormat]Format)
+
+// RegisterFormat makes a collection format available by name. A new format can be added in its
+// own file by calling RegisterFormat from an init function. It panics if the format is registered
+// twice.
+func RegisterFormat(f Format) {
+	if f.Parse == nil {
+		panic("cparser: RegisterFormat parser is nil")
+	}
+	if f.Name == Auto {
+		panic("cparser: RegisterFormat format name auto is reserved")
+	}
+	if _, ok := formats[f.Name]; ok {
+		panic("cparser: RegisterFormat called twice for format " + string(f.Name))
+	}
+	formats[f.Name] = f
+}
+
+// FormatFor returns a registered collection format.
+func FormatFor(name CollectionFormat) (Format, error) {
+	f, ok := formats[name]
+	if !ok {
+		return f, fmt.Errorf("%s is not a known collection format (see cparser formats)", name)
+	}
+	return f, nil
+}
+
+// Formats lists the registered collection formats by name.
+func Formats() []Format {
+	var l []Format
+	for _, f := range formats {
+		l = append(l, f)
+	}
+	sort.Slice(l, func(i, j int) bool {
+		return l[i].Name < l[j].Name
+	})
+	return l
+}
+
+// FormatForFile picks a collection format for a file based on its extension, ignoring any
+// compression extension. It is used when walking a collection with the auto format, and falls
+// back to trectext.
+func FormatForFile(path string) CollectionFormat {
+	name := strings.ToLower(trimCompressionExt(filepath.Base(path)))
+	for _, f := range Formats() {
+		for _, ext := range f.Extensions {
+			if strings.HasSuffix(name, ext) {
+				return f.Name
+			}
+		}
+	}
+	return TRECTEXT
+}
+
+func init() {
+	RegisterFormat(Format{
+		Name:        TRECTEXT,
+		Description: "TREC SGML documents, e.g., TREC disks 4 and 5 (robust04)",
+		Parse:       ParseTRECStream,
+		Fields:      trecFields,
+		Split:       splitTREC,
+	})
+	RegisterFormat(Format{
+		Name:        TRECWEB,
+		Description: "TREC documents, including web pages with a DOCHDR, e.g., GOV2",
+		Parse:       ParseTRECStream,
+		Fields:      trecFields,
+		Split:       splitTREC,
+	})
+	RegisterFormat(Format{
+		Name:        WashPost,
+		Description: "Washington Post articles, one JSON object per line (core18)",
+		Parse:       ParseWPStream,
+		Extensions:  []string{".jl", ".json"},
+		Fields:      wpFields,
+		Split:       splitLines,
+	})
+	RegisterFormat(Format{
+		Name:        WARC,
+		Description: "WARC response records with a WARC-TREC-ID, e.g., ClueWeb12 (cw12b)",
+		Parse:       ParseWARC,
+		Extensions:  []string{".warc"},
+		Fields:      webFields,
+		Compressed:  true,
+	})
+	RegisterFormat(Format{
+		Name:        NYT,
+		Description: "New York Times Annotated Corpus NITF articles, one per file (core17)",
+		Parse:       ParseNYTStream,
+		Extensions:  []string{".xml"},
+		Fields:      nytFields,
+	})
+}
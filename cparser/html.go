@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"html"
+	"strings"
+)
+
+// HTMLDocument is the readable content of an HTML page.
+type HTMLDocument struct {
+	Title       string
+	Description string
+	Headings    []string
+	Body        string
+}
+
+// Tags which separate words in the body text of a page.
+var blockTags = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "br": true, "dd": true,
+	"div": true, "dl": true, "dt": true, "footer": true, "form": true, "h1": true, "h2": true,
+	"h3": true, "h4": true, "h5": true, "h6": true, "header": true, "hr": true, "li": true,
+	"nav": true, "ol": true, "option": true, "p": true, "pre": true, "section": true,
+	"table": true, "td": true, "th": true, "title": true, "tr": true, "ul": true,
+}
+
+// Tags whose contents are never shown to the reader.
+var rawTextTags = map[string]bool{
+	"script": true,
+	"style":  true,
+}
+
+// StripHTTPHeader removes the HTTP response header block from the start of a WARC response.
+func StripHTTPHeader(b []byte) []byte {
+	if !bytes.HasPrefix(b, []byte("HTTP/")) {
+		return b
+	}
+	if i := bytes.Index(b, []byte("\r\n\r\n")); i >= 0 {
+		return b[i+4:]
+	}
+	if i := bytes.Index(b, []byte("\n\n")); i >= 0 {
+		return b[i+2:]
+	}
+	return nil
+}
+
+// ExtractHTML extracts the title, meta description, headings and body text of an HTML page.
+// Markup, comments, scripts and styles are dropped, and entities are decoded. The parser is
+// deliberately forgiving, since pages in web collections are rarely well-formed. As in a browser,
+// an unterminated comment, script or style runs to the end of the page, so the rest of the page
+// is dropped, as is an unterminated tag at the end of a (e.g., truncated) page.
+func ExtractHTML(b []byte) HTMLDocument {
+	var (
+		d                 HTMLDocument
+		body, title, head strings.Builder
+		inTitle, inHead   bool
+	)
+
+	text := func(t []byte) {
+		if len(t) == 0 {
+			return
+		}
+		s := html.UnescapeString(string(t))
+		if inTitle {
+			title.WriteString(s)
+			return
+		}
+		body.WriteString(s)
+		if inHead {
+			head.WriteString(s)
+		}
+	}
+
+	i := 0
+	for i < len(b) {
+		lt := bytes.IndexByte(b[i:], '<')
+		if lt < 0 {
+			text(b[i:])
+			break
+		}
+		text(b[i : i+lt])
+		i += lt
+
+		// Comments, declarations and processing instructions.
+		if bytes.HasPrefix(b[i:], []byte("<!--")) {
+			end := bytes.Index(b[i+4:], []byte("-->"))
+			if end < 0 {
+				// The comment runs to the end of the page.
+				break
+			}
+			i += 4 + end + 3
+			continue
+		}
+		if i+1 < len(b) && (b[i+1] == '!' || b[i+1] == '?') {
+			gt := bytes.IndexByte(b[i:], '>')
+			if gt < 0 {
+				break
+			}
+			i += gt + 1
+			continue
+		}
+
+		// Start and end tags.
+		j := i + 1
+		closing := j < len(b) && b[j] == '/'
+		if closing {
+			j++
+		}
+		k := j
+		for k < len(b) && isTagNameByte(b[k]) {
+			k++
+		}
+		if k == j {
+			// Not a tag, just a literal '<'.
+			text(b[i : i+1])
+			i++
+			continue
+		}
+		gt := tagEnd(b, k)
+		if gt < 0 {
+			break
+		}
+		name := strings.ToLower(string(b[j:k]))
+		attrs := b[k:gt]
+		i = gt + 1
+
+		if !closing && rawTextTags[name] {
+			end := indexEndTag(b[i:], name)
+			if end < 0 {
+				// The script or style runs to the end of the page.
+				break
+			}
+			i += end
+			continue
+		}
+
+		switch {
+		case name == "title":
+			inTitle = !closing
+		case len(name) == 2 && name[0] == 'h' && name[1] >= '1' && name[1] <= '6':
+			if !closing {
+				inHead = true
+			} else if inHead {
+				if h := normaliseSpace(head.String()); len(h) > 0 {
+					d.Headings = append(d.Headings, h)
+				}
+				head.Reset()
+				inHead = false
+			}
+		case name == "meta" && !closing:
+			a := parseAttrs(attrs)
+			if strings.EqualFold(a["name"], "description") {
+				d.Description = normaliseSpace(html.UnescapeString(a["content"]))
+			}
+		}
+		if blockTags[name] {
+			body.WriteByte(' ')
+		}
+	}
+
+	if inHead {
+		if h := normaliseSpace(head.String()); len(h) > 0 {
+			d.Headings = append(d.Headings, h)
+		}
+	}
+	d.Title = normaliseSpace(title.String())
+	d.Body = normaliseSpace(body.String())
+	return d
+}
+
+func isTagNameByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == ':'
+}
+
+// tagEnd finds the '>' closing a tag, ignoring any inside quoted attribute values. If the quotes
+// are unbalanced, the first '>' is used instead.
+func tagEnd(b []byte, i int) int {
+	var quote byte
+	for j := i; j < len(b); j++ {
+		c := b[j]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '>':
+			return j
+		}
+	}
+	if gt := bytes.IndexByte(b[i:], '>'); gt >= 0 {
+		return i + gt
+	}
+	return -1
+}
+
+// indexEndTag finds the end tag of the named element, ignoring case.
+func indexEndTag(b []byte, name string) int {
+	for i := 0; i < len(b); {
+		j := bytes.Index(b[i:], []byte("</"))
+		if j < 0 {
+			return -1
+		}
+		i += j
+		if end := i + 2 + len(name); end <= len(b) && strings.EqualFold(string(b[i+2:end]), name) {
+			return i
+		}
+		i += 2
+	}
+	return -1
+}
+
+// parseAttrs parses the attributes of a tag into a map keyed by lower-case attribute name.
+func parseAttrs(b []byte) map[string]string {
+	attrs := make(map[string]string)
+	i := 0
+	for i < len(b) {
+		for i < len(b) && (isSpace(b[i]) || b[i] == '/') {
+			i++
+		}
+		j := i
+		for j < len(b) && !isSpace(b[j]) && b[j] != '=' && b[j] != '/' {
+			j++
+		}
+		if j == i {
+			break
+		}
+		name := strings.ToLower(string(b[i:j]))
+		i = j
+		for i < len(b) && isSpace(b[i]) {
+			i++
+		}
+		if i >= len(b) || b[i] != '=' {
+			attrs[name] = ""
+			continue
+		}
+		i++
+		for i < len(b) && isSpace(b[i]) {
+			i++
+		}
+		if i < len(b) && (b[i] == '"' || b[i] == '\'') {
+			q := b[i]
+			i++
+			j = bytes.IndexByte(b[i:], q)
+			if j < 0 {
+				attrs[name] = string(b[i:])
+				break
+			}
+			attrs[name] = string(b[i : i+j])
+			i += j + 1
+			continue
+		}
+		j = i
+		for j < len(b) && !isSpace(b[j]) {
+			j++
+		}
+		attrs[name] = string(b[i:j])
+		i = j
+	}
+	return attrs
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// normaliseSpace collapses runs of whitespace into a single space.
+func normaliseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
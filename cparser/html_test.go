@@ -0,0 +1,83 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStripHTTPHeader(t *testing.T) {
+	var tests = []struct {
+		page     string
+		expected string
+	}{
+		{"HTTP/1.1 200 OK\r\nContent-Type: text/html\r\n\r\n<p>body</p>", "<p>body</p>"},
+		{"HTTP/1.0 200 OK\nContent-Type: text/html\n\n<p>body</p>", "<p>body</p>"},
+		{"<p>HTTP/1.1 is not a header</p>", "<p>HTTP/1.1 is not a header</p>"},
+		{"HTTP/1.1 200 OK\r\nContent-Type: text/html\r\n", ""},
+	}
+
+	for _, test := range tests {
+		if b := string(StripHTTPHeader([]byte(test.page))); b != test.expected {
+			t.Errorf("StripHTTPHeader(%q) = %q, expected %q", test.page, b, test.expected)
+		}
+	}
+}
+
+func TestExtractHTML(t *testing.T) {
+	var tests = []struct {
+		page     string
+		expected HTMLDocument
+	}{
+		{
+			`<html><head><title>The  Title</title>
+<meta name="Description" content="About &quot;this&quot; page">
+<style>p { color: red }</style>
+<script type="text/javascript">if (a < b) { document.write("<p>hidden</p>") }</script>
+</head><body><h1>First <b>heading</b></h1><p>Some text.</p><H2>Second</H2>more</body></html>`,
+			HTMLDocument{
+				Title:       "The Title",
+				Description: `About "this" page`,
+				Headings:    []string{"First heading", "Second"},
+				Body:        "First heading Some text. Second more",
+			},
+		},
+		{
+			"<p>caf&eacute; &amp; bar&#39;s &lt;tag&gt; &#x41;&nbsp;b</p>",
+			HTMLDocument{Body: "café & bar's <tag> A b"},
+		},
+		{
+			`<p>one<br>two</p><div>three</div><span>fo</span>ur <a href="a>b">link</a> 1 < 2`,
+			HTMLDocument{Body: "one two three four link 1 < 2"},
+		},
+		{
+			"<!DOCTYPE html><?xml version=\"1.0\"?><!-- a <p>comment</p> --><p>text</p><SCRIPT>x</script >after",
+			HTMLDocument{Body: "text after"},
+		},
+		// An unterminated comment, script or style runs to the end of the page.
+		{
+			"<p>before</p><!-- never closed <p>after</p>",
+			HTMLDocument{Body: "before"},
+		},
+		{
+			"<p>before</p><script>document.write('<p>after</p>')",
+			HTMLDocument{Body: "before"},
+		},
+		{
+			"<h1>before</h1><style>p { color: red }",
+			HTMLDocument{Headings: []string{"before"}, Body: "before"},
+		},
+		// An unterminated tag at the end of a truncated page is dropped, but the text before it is
+		// kept, even in an unclosed heading.
+		{
+			"<h2>cut short<a href=\"http://example.com/",
+			HTMLDocument{Headings: []string{"cut short"}, Body: "cut short"},
+		},
+	}
+
+	for _, test := range tests {
+		d := ExtractHTML([]byte(test.page))
+		if !reflect.DeepEqual(d, test.expected) {
+			t.Errorf("ExtractHTML(%q) = %#v, expected %#v", test.page, d, test.expected)
+		}
+	}
+}
@@ -7,30 +7,34 @@ import (
 	"bytes"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/datatogether/warc"
+	"github.com/osirrc2019/ielab-docker/cparser/analysis"
+	"html"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
-	"unicode/utf8"
+	"time"
 )
 
 // Start and end tokens in TREC collections.
 const (
-	StartToken = "<DOC>"
-	EndToken   = "</DOC>"
+	StartToken     = "<DOC>"
+	EndToken       = "</DOC>"
+	DocHdrToken    = "<DOCHDR>"
+	DocHdrEndToken = "</DOCHDR>"
 )
 
-// Current state of the reader.
-type readState int
-
-const (
-	Reading readState = iota
-	Skipping
-)
+// docNoRe finds the DOCNO of a TREC document.
+var docNoRe = regexp.MustCompile(`(?s)<DOCNO>(.*?)</DOCNO>`)
 
 // Collection formats.
 type CollectionFormat string
@@ -41,6 +45,7 @@ const (
 	WashPost                  = "wp"
 	WARC                      = "warc"
 	NYT                       = "nyt"
+	Auto                      = "auto"
 )
 
 type TRECWEBDoc struct {
@@ -75,7 +80,7 @@ type WaPostArticle struct {
 	ArticleURL    string `json:"article_url"`
 	Title         string `json:"title"`
 	Author        string `json:"author"`
-	PublishedDate int    `json:"published_date"`
+	PublishedDate int64  `json:"published_date"`
 	Type          string `json:"type"`
 	Source        string `json:"source"`
 	Contents      []struct {
@@ -84,10 +89,10 @@ type WaPostArticle struct {
 		Mime        string      `json:"mime"`
 		Content     interface{} `json:"content,omitempty"`
 		Text        string      `json:"text,omitempty"`
-		FullCaption string      `json:"full_caption,omitempty"`
+		FullCaption string      `json:"fullcaption,omitempty"`
 		ImageURL    string      `json:"imageURL,omitempty"`
-		ImageHeight int         `json:"image_height,omitempty"`
-		ImageWidth  int         `json:"image_width,omitempty"`
+		ImageHeight int         `json:"imageHeight,omitempty"`
+		ImageWidth  int         `json:"imageWidth,omitempty"`
 		Blurb       string      `json:"blurb"`
 		Role        string      `json:"role"`
 		Bio         string      `json:"bio"`
@@ -97,37 +102,119 @@ type WaPostArticle struct {
 type NYTArticle struct {
 	XMLName xml.Name `xml:"nitf"`
 	Head    struct {
-		Title   string `xml:"title"`
+		Title string `xml:"title"`
+		Meta  []struct {
+			Name    string `xml:"name,attr"`
+			Content string `xml:"content,attr"`
+		} `xml:"meta"`
 		DocData struct {
 			DocId struct {
 				ID string `xml:"id-string,attr"`
 			} `xml:"doc-id"`
+			IdentifiedContent struct {
+				Classifiers []struct {
+					Class string `xml:"class,attr"`
+					Type  string `xml:"type,attr"`
+					Value string `xml:",chardata"`
+				} `xml:"classifier"`
+				Locations     []string `xml:"location"`
+				People        []string `xml:"person"`
+				Organisations []string `xml:"org"`
+			} `xml:"identified-content"`
 		} `xml:"docdata"`
+		PubData struct {
+			Date string `xml:"date.publication,attr"`
+			URL  string `xml:"ex-ref,attr"`
+		} `xml:"pubdata"`
 	} `xml:"head"`
 	Body struct {
-		Value string `xml:",innerxml"`
+		Head struct {
+			Headline struct {
+				HL1 string `xml:"hl1"`
+			} `xml:"hedline"`
+			Bylines []struct {
+				Class string `xml:"class,attr"`
+				Value string `xml:",chardata"`
+			} `xml:"byline"`
+			Abstract struct {
+				P []InnerResult `xml:"p"`
+			} `xml:"abstract"`
+		} `xml:"body.head"`
+		Content struct {
+			Blocks []struct {
+				Class string        `xml:"class,attr"`
+				P     []InnerResult `xml:"p"`
+			} `xml:"block"`
+		} `xml:"body.content"`
 	} `xml:"body"`
 }
 
-type CollectionParser func(r io.Reader) ([]byte, error)
+// nitfParagraphs extracts the text of NITF paragraphs, which may contain inline markup such as
+// <person> or <a>, keeping the text of the markup but not the tags.
+func nitfParagraphs(ps []InnerResult) []string {
+	text := make([]string, 0, len(ps))
+	for _, p := range ps {
+		text = append(text, ExtractHTML([]byte(p.Value)).Body)
+	}
+	return text
+}
+
+// NITF date formats.
+const (
+	nitfDateFormat = "20060102T150405"
+	dateFormat     = "2006-01-02"
+)
+
+// WebPage is a document created from an HTML page, e.g., from ClueWeb or GOV2.
+type WebPage struct {
+	DocNo       string
+	URL         string   `json:",omitempty"`
+	Title       string   `json:",omitempty"`
+	Description string   `json:",omitempty"`
+	Headings    []string `json:",omitempty"`
+	Text        string
+}
+
+// NewWebPage extracts the content of an HTML page, which may begin with an HTTP header block.
+func NewWebPage(docNo, url string, content []byte) WebPage {
+	h := ExtractHTML(StripHTTPHeader(content))
+	return WebPage{
+		DocNo:       docNo,
+		URL:         url,
+		Title:       h.Title,
+		Description: h.Description,
+		Headings:    h.Headings,
+		Text:        h.Body,
+	}
+}
+
+func ParseTRECWEB(r io.Reader) (Document, error) {
+	d := TRECWEBDoc{}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return Document{}, err
+	}
+
+	// Web documents (e.g., GOV2) contain an HTML page rather than pseudo-xml.
+	if bytes.Contains(b, []byte(DocHdrEndToken)) {
+		return ParseTRECWEBPage(b)
+	}
 
-func ParseTRECWEB(r io.Reader) ([]byte, string, error) {
-	var (
-		d    = TRECWEBDoc{}
-		buff = new(bytes.Buffer)
-	)
 	// Decode the pseudo-xml data into a TRECWEBDoc.
-	err := xml.NewDecoder(r).Decode(&d)
+	r = bytes.NewReader(b)
+	err = xml.NewDecoder(r).Decode(&d)
 	if err != nil {
-		b, _ := ioutil.ReadAll(r)
-		fmt.Println(string(b))
-		panic(err)
-		return nil, "", err
+		return Document{}, err
 	}
+	docNo := strings.TrimSpace(d.DocNo)
 
 	// Transform the doc into a TRECWEBDoc and clean it up.
 	var j interface{}
 	if d.Body != nil { // If the document has a body tag, it's probably NYT.
+		var text string
+		if d.Body.Text != nil {
+			text = strings.Join(d.Body.Text.P, " ")
+		}
 		j = struct {
 			Headline string
 			Slug     string
@@ -140,207 +227,779 @@ func ParseTRECWEB(r io.Reader) ([]byte, string, error) {
 		}{
 			Headline: strings.TrimSpace(d.Body.Headline),
 			Slug:     strings.TrimSpace(d.Body.Slug),
-			Text:     strings.Join(d.Body.Text.P, " "),
+			Text:     text,
 			DateTime: strings.TrimSpace(d.DateTime),
-			DocNo:    strings.TrimSpace(d.DocNo),
+			DocNo:    docNo,
 			DocType:  strings.TrimSpace(d.DocType),
 			Header:   strings.TrimSpace(d.Header),
 			Trailer:  strings.TrimSpace(d.Trailer),
 		}
+	} else if source, ok := TRECSourceFor(docNo); ok { // TREC disks 4 and 5.
+		j, err = source.Extract(docNo, b)
+		if err != nil {
+			return Document{}, err
+		}
 	} else { // Otherwise, just put everything into TEXT.
 		j = struct {
 			DocNo string
 			Text  string
 		}{
-			DocNo: strings.TrimSpace(d.DocNo),
-			Text:  strings.TrimSpace(d.Text.Value),
+			DocNo: docNo,
+			Text:  strings.TrimSpace(html.UnescapeString(d.Text.Value)),
 		}
 	}
-
-	// Encode the TRECWEBDoc into raw JSON.
-	err = json.NewEncoder(buff).Encode(&j)
-	if err != nil {
-		return nil, "", err
-	}
-	return buff.Bytes(), d.DocNo, nil
+	return NewDocument(docNo, j)
 }
 
-func ParseWP(r io.Reader) ([]byte, string, error) {
+// ParseTRECWEBPage parses a TRECWEB document which contains a web page, i.e., a DOCNO, a DOCHDR
+// with the URL and HTTP headers of the page, followed by the HTML of the page.
+func ParseTRECWEBPage(b []byte) (Document, error) {
 	var (
-		d    WaPostArticle
-		buff = new(bytes.Buffer)
+		docNo string
+		url   string
 	)
+	if m := docNoRe.FindSubmatch(b); m != nil {
+		docNo = strings.TrimSpace(string(m[1]))
+	}
+	if i := bytes.Index(b, []byte(DocHdrToken)); i >= 0 {
+		// The first line of the DOCHDR is the URL of the page.
+		hdr := bytes.TrimSpace(b[i+len(DocHdrToken):])
+		if j := bytes.IndexAny(hdr, " \r\n"); j >= 0 {
+			hdr = hdr[:j]
+		}
+		url = string(hdr)
+	}
+	if i := bytes.Index(b, []byte(DocHdrEndToken)); i >= 0 {
+		b = b[i+len(DocHdrEndToken):]
+	}
+	b = bytes.TrimSuffix(bytes.TrimSpace(b), []byte(EndToken))
+
+	return NewDocument(docNo, NewWebPage(docNo, url, b))
+}
+
+// WaPostDocument is the document indexed for a Washington Post article.
+type WaPostDocument struct {
+	Id            string   `json:"id"`
+	ArticleURL    string   `json:"article_url"`
+	Title         string   `json:"title"`
+	Author        string   `json:"author"`
+	PublishedDate string   `json:"published_date,omitempty"`
+	Type          string   `json:"type"`
+	Source        string   `json:"source"`
+	Text          string   `json:"text"`
+	Captions      []string `json:"captions,omitempty"`
+	DuplicateIds  []string `json:"duplicate_ids,omitempty"`
+}
+
+// WaPost content blocks which contain the text of an article.
+var waPostTextBlocks = map[string]bool{
+	"sanitized_html": true,
+	"kicker":         true,
+	"title":          true,
+	"byline":         true,
+}
+
+func ParseWP(r io.Reader) (Document, error) {
+	var d WaPostArticle
 	err := json.NewDecoder(r).Decode(&d)
 	if err != nil {
-		return nil, "", err
+		return Document{}, err
 	}
 
-	for i, c := range d.Contents {
-		d.Contents[i].Text = fmt.Sprintf("%v", c.Content)
-		d.Contents[i].Content = ""
+	j := WaPostDocument{
+		Id:         d.Id,
+		ArticleURL: d.ArticleURL,
+		Title:      d.Title,
+		Author:     d.Author,
+		Type:       d.Type,
+		Source:     d.Source,
 	}
 
-	err = json.NewEncoder(buff).Encode(&d)
-	return buff.Bytes(), d.Id, err
-}
+	// The published date is in milliseconds since the epoch.
+	if d.PublishedDate > 0 {
+		j.PublishedDate = time.Unix(0, d.PublishedDate*int64(time.Millisecond)).UTC().Format(time.RFC3339)
+	}
 
-func ParseWARC(r io.Reader) ([][]byte, []string, error) {
-	reader, err := warc.NewReader(r)
-	if err != nil {
-		return nil, nil, err
+	// Only paragraph-like blocks are part of the text, and image captions are kept separately.
+	var text []string
+	for _, c := range d.Contents {
+		if c.Type == "image" {
+			if caption := ExtractHTML([]byte(c.FullCaption)).Body; len(caption) > 0 {
+				j.Captions = append(j.Captions, caption)
+			}
+			continue
+		}
+		content, ok := c.Content.(string)
+		if !ok || !waPostTextBlocks[c.Type] {
+			continue
+		}
+		if t := ExtractHTML([]byte(content)).Body; len(t) > 0 {
+			text = append(text, t)
+		}
 	}
-	records, err := reader.ReadAll()
+	j.Text = strings.Join(text, " ")
+	return NewDocument(d.Id, j)
+}
+
+// ParseWARC reads the records of a WARC file one at a time. Only response records which have a
+// WARC-TREC-ID are written as documents.
+func ParseWARC(name string, r io.Reader, w DocumentWriter) (int, error) {
+	reader, err := NewWARCReader(r)
 	if err != nil {
-		return nil, nil, err
+		return 0, err
 	}
 
-	recs := make([][]byte, len(records))
-	ids := make([]string, len(records))
+	var n int
+	for {
+		rec, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, err
+		}
 
-	for i, rec := range records {
-		buff := new(bytes.Buffer)
-		j := struct {
-			DocNo string
-			Text  string
-		}{
-			DocNo: strings.TrimSpace(rec.Headers.Get("WARC-TREC-ID")),
-			Text:  strings.TrimSpace(rec.Content.String()),
+		id := strings.TrimSpace(rec.Headers.Get("WARC-TREC-ID"))
+		if rec.Type != warc.RecordTypeResponse || len(id) == 0 {
+			continue
+		}
+
+		// The charset of a page is declared by the record or its HTTP headers, or by the page itself.
+		content := rec.Content.Bytes()
+		charset := contentTypeCharset(rec.Headers.Get(warc.FieldNameContentType))
+		if len(charset) == 0 {
+			charset = PageCharset(content)
+		}
+		content, charset, counts := Transcode(content, charset)
+		transcoded.Add(name, charset, counts)
+
+		doc, err := NewDocument(id, NewWebPage(id, rec.Headers.Get(warc.FieldNameWARCTargetURI), content))
+		if err != nil {
+			return n, err
 		}
-		err = json.NewEncoder(buff).Encode(j)
+		doc.SetMetadata("file", name)
+		doc.SetMetadata("charset", charset)
+		err = w.Write(doc)
 		if err != nil {
-			return nil, nil, err
+			return n, err
 		}
-		recs[i] = buff.Bytes()
-		ids[i] = rec.Headers.Get("WARC-TREC-ID")
+		n++
 	}
 
-	return recs, ids, nil
+	if reader.Malformed > 0 {
+		log.Printf("skipped %d malformed WARC records\n", reader.Malformed)
+	}
+	return n, nil
 }
 
-func ParseNYT(r io.Reader) ([]byte, string, error) {
-	var (
-		d    NYTArticle
-		buff = new(bytes.Buffer)
-	)
+func ParseNYT(r io.Reader) (Document, error) {
+	var d NYTArticle
 	err := xml.NewDecoder(r).Decode(&d)
 	if err != nil {
-		return nil, "", err
+		return Document{}, err
 	}
 
 	j := struct {
-		DocNo string `json:"id"`
-		Title string `json:"title"`
-		Text  string `json:"text"`
+		DocNo                string   `json:"id"`
+		Title                string   `json:"title"`
+		Headline             string   `json:"headline,omitempty"`
+		Byline               string   `json:"byline,omitempty"`
+		Abstract             string   `json:"abstract,omitempty"`
+		LeadParagraph        string   `json:"lead_paragraph,omitempty"`
+		Text                 string   `json:"text"`
+		Published            string   `json:"published,omitempty"`
+		URL                  string   `json:"url,omitempty"`
+		Desk                 string   `json:"desk,omitempty"`
+		OnlineSections       []string `json:"online_sections,omitempty"`
+		Descriptors          []string `json:"descriptors,omitempty"`
+		GeneralDescriptors   []string `json:"general_descriptors,omitempty"`
+		TaxonomicClassifiers []string `json:"taxonomic_classifiers,omitempty"`
+		Locations            []string `json:"locations,omitempty"`
+		People               []string `json:"people,omitempty"`
+		Organisations        []string `json:"organisations,omitempty"`
 	}{
-		DocNo: d.Head.DocData.DocId.ID,
-		Title: d.Head.Title,
-		Text:  d.Body.Value,
+		DocNo:         d.Head.DocData.DocId.ID,
+		Title:         normaliseSpace(d.Head.Title),
+		Headline:      normaliseSpace(d.Body.Head.Headline.HL1),
+		Abstract:      normaliseSpace(strings.Join(nitfParagraphs(d.Body.Head.Abstract.P), " ")),
+		URL:           d.Head.PubData.URL,
+		Locations:     trimAll(d.Head.DocData.IdentifiedContent.Locations),
+		People:        trimAll(d.Head.DocData.IdentifiedContent.People),
+		Organisations: trimAll(d.Head.DocData.IdentifiedContent.Organisations),
 	}
 
-	err = json.NewEncoder(buff).Encode(&j)
-	if err != nil {
-		return nil, "", err
+	// Prefer the byline as printed over the normalised byline.
+	for _, b := range d.Body.Head.Bylines {
+		if len(j.Byline) == 0 || b.Class == "print_byline" {
+			j.Byline = normaliseSpace(b.Value)
+		}
 	}
 
-	return buff.Bytes(), j.DocNo, nil
+	// The body is split into a lead paragraph and the full text of the article.
+	var text []string
+	for _, b := range d.Body.Content.Blocks {
+		switch b.Class {
+		case "lead_paragraph":
+			j.LeadParagraph = normaliseSpace(strings.Join(nitfParagraphs(b.P), " "))
+		case "full_text":
+			text = append(text, nitfParagraphs(b.P)...)
+		}
+	}
+	if len(text) == 0 {
+		for _, b := range d.Body.Content.Blocks {
+			text = append(text, nitfParagraphs(b.P)...)
+		}
+	}
+	j.Text = normaliseSpace(strings.Join(text, " "))
+
+	// The publication date is in the pubdata, but otherwise can be found in the meta tags.
+	meta := make(map[string]string)
+	for _, m := range d.Head.Meta {
+		meta[m.Name] = m.Content
+	}
+	if t, err := time.Parse(nitfDateFormat, d.Head.PubData.Date); err == nil {
+		j.Published = t.Format(dateFormat)
+	} else if t, err := time.Parse("2006-1-2", fmt.Sprintf("%s-%s-%s", meta["publication_year"], meta["publication_month"], meta["publication_day_of_month"])); err == nil {
+		j.Published = t.Format(dateFormat)
+	}
+	j.Desk = meta["dsk"]
+	if s, ok := meta["online_sections"]; ok {
+		j.OnlineSections = trimAll(strings.Split(s, ";"))
+	}
+
+	for _, c := range d.Head.DocData.IdentifiedContent.Classifiers {
+		v := strings.TrimSpace(c.Value)
+		switch c.Type {
+		case "descriptor":
+			j.Descriptors = append(j.Descriptors, v)
+		case "general_descriptor":
+			j.GeneralDescriptors = append(j.GeneralDescriptors, v)
+		case "taxonomic_classifier":
+			j.TaxonomicClassifiers = append(j.TaxonomicClassifiers, v)
+		}
+	}
+
+	return NewDocument(j.DocNo, j)
 }
 
-func ParseJSON(r io.Reader) ([]byte, error) {
-	return ioutil.ReadAll(r)
+// trimAll trims the space around each string, removing any empty strings.
+func trimAll(s []string) []string {
+	var t []string
+	for _, v := range s {
+		if v = strings.TrimSpace(v); len(v) > 0 {
+			t = append(t, v)
+		}
+	}
+	return t
 }
 
-func fixUtf(r rune) rune {
-	if r == utf8.RuneError {
-		return -1
+var (
+	xmlUnquotedAttrRe = regexp.MustCompile(`[a-zA-Z]+=[a-zA-Z0-9\-]+`) // Regex to remove unquoted XML attributes.
+)
+
+// ParseTRECStream splits a TREC collection file into <DOC> elements and parses each one. A
+// document which cannot be parsed (or is larger than MaxTRECDocBytes) is quarantined, and if its
+// DOCNO can be recovered, the text between its tags is indexed instead.
+func ParseTRECStream(name string, r io.Reader, w DocumentWriter) (int, error) {
+	var (
+		tokenizer = NewTRECTokenizer(r, MaxTRECDocBytes)
+		parser    = ParseTRECWEB
+		n         int
+	)
+	for {
+		doc, err := tokenizer.Next()
+		if err == io.EOF {
+			return n, nil
+		}
+		switch {
+		case err == io.ErrUnexpectedEOF:
+			err = errors.New("file ends inside the document")
+		case err != nil:
+			return n, err
+		case doc.TooLarge:
+			err = fmt.Errorf("document is larger than %d bytes", MaxTRECDocBytes)
+		}
+		if err != nil {
+			// The document is incomplete, so it is only quarantined.
+			var docNo string
+			if m := docNoRe.FindSubmatch(doc.Bytes); m != nil {
+				docNo = strings.TrimSpace(string(m[1]))
+			}
+			err = quarantine.Add(QuarantineEntry{
+				File:   name,
+				Offset: doc.Start,
+				DocNo:  docNo,
+				Error:  err.Error(),
+			})
+			if err != nil {
+				return n, err
+			}
+			continue
+		}
+
+		b, charset, counts := Transcode(doc.Bytes, doc.Charset())
+		transcoded.Add(name, charset, counts)
+		t := entities.Decode(string(b))
+		b = []byte(xmlUnquotedAttrRe.ReplaceAllString(t, ""))
+		d, err := parser(bytes.NewReader(b))
+		if err != nil {
+			d, _ = ParseTRECLenient(b)
+			err = quarantine.Add(QuarantineEntry{
+				File:   name,
+				Offset: doc.Start,
+				DocNo:  d.ID,
+				Error:  err.Error(),
+			})
+			if err != nil {
+				return n, err
+			}
+		}
+		if len(d.ID) == 0 {
+			continue
+		}
+		d.SetMetadata("file", name)
+		d.SetMetadata("offset", strconv.FormatInt(doc.Start, 10))
+		d.SetMetadata("length", strconv.FormatInt(doc.End-doc.Start, 10))
+		d.SetMetadata("charset", charset)
+		err = w.Write(d)
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// ParseWPStream parses the Washington Post articles in a JSON lines file, one article per line.
+// A line which cannot be parsed is quarantined.
+func ParseWPStream(name string, r io.Reader, w DocumentWriter) (int, error) {
+	var (
+		br     = bufio.NewReader(r)
+		n      int
+		offset = streamOffset(r)
+	)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return n, err
+		}
+		start := offset
+		offset += int64(len(line))
+		if len(bytes.TrimSpace(line)) > 0 {
+			d, perr := ParseWP(bytes.NewReader(line))
+			if perr != nil {
+				qerr := quarantine.Add(QuarantineEntry{
+					File:   name,
+					Offset: start,
+					Error:  perr.Error(),
+				})
+				if qerr != nil {
+					return n, qerr
+				}
+			} else {
+				d.SetMetadata("file", name)
+				d.SetMetadata("offset", strconv.FormatInt(start, 10))
+				if werr := w.Write(d); werr != nil {
+					return n, werr
+				}
+				n++
+			}
+		}
+		if err == io.EOF {
+			return n, nil
+		}
+	}
+}
+
+// ParseNYTStream parses a NYT article.
+func ParseNYTStream(name string, r io.Reader, w DocumentWriter) (int, error) {
+	d, err := ParseNYT(r)
+	if err != nil {
+		return 0, err
 	}
-	return r
+	d.SetMetadata("file", name)
+	return 1, w.Write(d)
 }
 
 func main() {
 	var (
-		format            CollectionFormat = "trecweb"                                      // The default collection format.
-		buff                               = new(bytes.Buffer)                              // Buffer to store the current document.
-		state                              = Skipping                                       // State the collectionPath reader is in.
-		xmlEntRe                           = regexp.MustCompile(`&.*;|&|\|`)                // Regex to filter out XML entities.
-		xmlUnquotedAttrRe                  = regexp.MustCompile(`[a-zA-Z]+=[a-zA-Z0-9\-]+`) // Regex to remove unquoted XML attributes.
+		bulk        = flag.Bool("bulk", false, "send bulk requests to Elasticsearch instead of writing them to stdout")
+		esURL       = flag.String("es", "http://localhost:9200", "url of the Elasticsearch instance")
+		opType      = flag.String("op", "index", "bulk operation for documents: index, create (fail if the document exists) or update (upsert)")
+		routing     = flag.String("routing", "", "document field whose value routes documents to shards")
+		pipeline    = flag.String("pipeline", "", "ingest pipeline to send documents through")
+		output      = flag.String("output", "es", "format documents are written in: es (bulk actions), jsonl (Anserini JsonCollection), trectext or solr")
+		outputDir   = flag.String("output-dir", "", "directory to write documents to as sharded files with a manifest, instead of stdout")
+		shardBytes  = flag.Int64("shard-bytes", 256<<20, "maximum size in bytes of each file written to the output directory")
+		state       = flag.String("state", "", "directory to keep a checkpoint ledger in, so that an interrupted bulk index can be resumed")
+		verify      = flag.Bool("verify", false, "check the number of documents in the index against the checkpoint ledger, instead of indexing")
+		statsJSON   = flag.String("stats-json", "", "file to write collection statistics to as JSON")
+		topTerms    = flag.Int("top-terms", 20, "number of top terms in collection statistics")
+		bulkDocs    = flag.Int("bulk-docs", 1000, "maximum number of documents in a bulk request")
+		bulkBytes   = flag.Int("bulk-bytes", 5<<20, "maximum size in bytes of a bulk request")
+		retries     = flag.Int("retries", 5, "number of times to retry documents rejected with 429 or 503")
+		deadLetter  = flag.String("dead-letter", "", "file to write documents that could not be indexed to")
+		quarantined = flag.String("quarantine", "", "file to record documents that could not be parsed to")
+		duplicates  = flag.String("duplicates", "", "policy for duplicate Washington Post articles: first, latest or merge")
+		dupReport   = flag.String("duplicates-report", "", "file to write the ids of collapsed duplicate articles to")
+		nearDups    = flag.Bool("near-duplicates", false, "mark documents with a SimHash fingerprint and the id of their cluster of near duplicates")
+		nearReport  = flag.String("near-duplicates-report", "", "file to write the clusters of near-duplicate documents to")
+		nearBits    = flag.Int("near-distance", nearDuplicateDistance, "maximum number of bits the fingerprints of near duplicates differ by (at most 3)")
+		unknownEnts = flag.Bool("report-entities", false, "report the unknown entities removed from documents")
+		root        = flag.String("path", "", "collection directory to walk instead of reading a file from stdin")
+		workers     = flag.Int("workers", runtime.NumCPU(), "number of files (or chunks of stdin) to parse at once")
+		buffer      = flag.Int("buffer", 1000, "number of parsed documents to buffer for each file (or chunk) waiting to be written")
+		transcoding = flag.String("transcode-report", "", "file to write the number of bytes transcoded to UTF-8 in each collection file to")
+		maxDocBytes = flag.Int("max-doc-bytes", MaxTRECDocBytes, "largest TREC document to parse; larger documents are quarantined")
+		chunkBytes  = flag.Int("chunk-bytes", 4<<20, "size of the chunks stdin is cut into to be parsed concurrently")
+		profile     = flag.String("profile", "", "name of a built-in collection profile, or path to a profile file")
+		analyzer    = flag.String("analyzer", "porter", "analyzer for text fields in the index mapping: english, porter or krovetz")
+		shards      = flag.Int("shards", 4, "number of shards in the index mapping")
+		variants    = flag.String("variants", "", "comma-separated analyzers to also index text fields with as subfields, e.g., raw,shingles")
+		pretokenize = flag.String("pretokenize", "", "analyzer to pre-tokenize text fields with, to be indexed with a whitespace analyzer: raw, stopped or porter")
+		stopwords   = flag.String("stopwords", "lucene", "stop list pre-tokenized text fields are stopped with: lucene, smart or inquery")
+		passageUnit = flag.String("passages", "", "split documents into passages of words, sentences or paragraphs, which are written to a passage index")
+		passageLen  = flag.Int("passage-size", 0, "number of words, sentences or paragraphs in a passage (150 words, 4 sentences or 1 paragraph by default)")
+		passageStep = flag.Int("passage-stride", 0, "number of words, sentences or paragraphs between the starts of passages (half the passage size by default)")
+		passageIdx  = flag.String("passage-index", "", "index passages are written to (the document index with a _passages suffix by default)")
+		include     globs
+		exclude     globs
 	)
+	flag.Var(&include, "include", "only parse files matching this glob when walking a collection (repeatable)")
+	flag.Var(&exclude, "exclude", "skip files and directories matching this glob when walking a collection (repeatable)")
+	flag.Parse()
+
+	quarantine.Path = *quarantined
+	transcoded.Path = *transcoding
+	if *maxDocBytes < len(StartToken)+len(EndToken) {
+		log.Fatalf("-max-doc-bytes must be at least %d, the size of an empty document\n", len(StartToken)+len(EndToken))
+	}
+	MaxTRECDocBytes = *maxDocBytes
+	entities.Report = *unknownEnts
 
-	// The name and path of the collection.
-	collectionName := os.Args[1]
+	// Print a profile, which can be used as the starting point for a new collection.
+	if flag.Arg(0) == "profile" {
+		p, err := LoadProfile(flag.Arg(1))
+		if err != nil {
+			log.Fatalln(err)
+		}
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+		err = e.Encode(p)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
 
-	// Determine the parser for collections to use.
-	format = CollectionFormat(os.Args[2])
-
-	// Standard trec collection files (e.g., robust04)
-	if format == TRECTEXT || format == TRECWEB {
-		parser := ParseTRECWEB
-
-		// Read and parse the collectionPath.
-		scanner := bufio.NewScanner(os.Stdin)
-		for scanner.Scan() {
-			t := xmlEntRe.ReplaceAllString(scanner.Text(), "")
-			t = xmlUnquotedAttrRe.ReplaceAllString(t, "")
-			t = strings.Map(fixUtf, t)
-			if state == Skipping && t == StartToken {
-				state = Reading
-			}
+	// List the collection formats which can be parsed.
+	if flag.Arg(0) == "formats" {
+		for _, f := range Formats() {
+			fmt.Printf("%-10s %s\n", f.Name, f.Description)
+		}
+		return
+	}
 
-			if state == Reading {
-				_, err := buff.WriteString(t)
-				if err != nil {
-					log.Fatalln(err)
+	// Load the collection profile, if there is one. Command-line arguments take precedence.
+	var p Profile
+	if len(*profile) > 0 {
+		var err error
+		p, err = LoadProfile(*profile)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "bulk-docs":
+			p.BulkDocs = *bulkDocs
+		case "bulk-bytes":
+			p.BulkBytes = *bulkBytes
+		case "variants":
+			p.Variants = nil
+			for _, v := range strings.Split(*variants, ",") {
+				if v = strings.TrimSpace(v); len(v) > 0 {
+					p.Variants = append(p.Variants, v)
 				}
 			}
+		}
+	})
 
-			if state == Reading && t == EndToken {
-				state = Skipping
-				data, id, err := parser(buff)
-				if err != nil {
-					log.Fatalln(err)
-				}
-				_, err = os.Stdout.WriteString(fmt.Sprintf(`{ "index": { "_index": "%s", "_id": "%s" } }
-%s`, collectionName, id, data))
-				if err != nil {
-					log.Fatalln(err)
-				}
+	// Print the settings and mappings of an index for the collection format.
+	if flag.Arg(0) == "mapping" {
+		if len(flag.Arg(1)) > 0 {
+			p.Format = CollectionFormat(flag.Arg(1))
+		}
+		if len(p.Format) == 0 {
+			p.Format = TRECWEB
+		}
+		// Pre-tokenized text is indexed as the terms cparser produced.
+		if len(*pretokenize) > 0 {
+			if len(p.Variants) > 0 {
+				log.Fatalln("-variants cannot be used with -pretokenize")
 			}
+			*analyzer = WhitespaceAnalyzer
+		}
+		var (
+			m   map[string]interface{}
+			err error
+		)
+		if len(*passageUnit) > 0 {
+			m, err = PassageMapping(*analyzer, p.Variants, *shards)
+		} else {
+			m, err = Mapping(p.Format, *analyzer, p.Variants, *shards, p.Fields)
 		}
-		// Washington Post (core18)
-	} else if format == WashPost {
-		data, id, err := ParseWP(os.Stdin)
 		if err != nil {
 			log.Fatalln(err)
 		}
-		_, err = os.Stdout.WriteString(fmt.Sprintf(`{ "index": { "_index": "%s", "_id": "%s"  } }
-%s`, collectionName, id, data))
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+		err = e.Encode(m)
 		if err != nil {
 			log.Fatalln(err)
 		}
-		// WARC (ClueWeb 12)
-	} else if format == WARC {
-		records, ids, err := ParseWARC(os.Stdin)
+		return
+	}
+
+	// The name and path of the collection. Statistics about the collection can be collected
+	// instead of indexing it, in which case there is no index.
+	args := flag.Args()
+	collectStats := len(args) > 0 && args[0] == "stats"
+	if collectStats {
+		args = args[1:]
+	} else if len(args) > 0 {
+		if len(args[0]) > 0 {
+			p.Index = args[0]
+		}
+		args = args[1:]
+	}
+
+	// Determine the parser for collections to use.
+	if len(args) > 0 && len(args[0]) > 0 {
+		p.Format = CollectionFormat(args[0])
+	}
+	if len(p.Format) == 0 {
+		p.Format = TRECWEB // The default collection format.
+	}
+	p.Include = append(p.Include, include...)
+	p.Exclude = append(p.Exclude, exclude...)
+
+	// Check a previous bulk index against its checkpoint ledger.
+	if *verify {
+		if len(*state) == 0 {
+			log.Fatalln("-verify requires the -state directory of the index")
+		}
+		err := Verify(*esURL, p.Index, *state)
 		if err != nil {
 			log.Fatalln(err)
 		}
-		for i, data := range records {
-			_, err = os.Stdout.WriteString(fmt.Sprintf(`{ "index": { "_index": "%s", "_id": "%s"  } }
-%s`, collectionName, ids[i], data))
+		return
+	}
+
+	if len(*passageUnit) > 0 {
+		if collectStats {
+			log.Fatalln("-passages cannot be used with stats")
+		}
+		if len(*state) > 0 {
+			log.Fatalln("-passages cannot be used with -state, which only keeps track of documents")
+		}
+	}
+
+	// Determine where the parsed documents are written to.
+	op, err := ParseOpType(*opType)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	action := BulkAction{Index: p.Index, Op: op, Routing: *routing, Pipeline: *pipeline}
+	newBulkIndexer := func(action BulkAction) *BulkIndexer {
+		b := NewBulkIndexer(*esURL, action.Index)
+		b.BulkAction = action
+		if p.BulkDocs > 0 {
+			b.MaxDocs = p.BulkDocs
+		}
+		if p.BulkBytes > 0 {
+			b.MaxBytes = p.BulkBytes
+		}
+		b.MaxRetries = *retries
+		b.DeadLetter = *deadLetter
+		return b
+	}
+	var (
+		w          DocumentWriter = StdoutWriter{action}
+		stats      *StatsWriter
+		checkpoint *Checkpoint
+		format     = OutputFormat(*output)
+	)
+	if collectStats {
+		stats = NewStatsWriter(*root, OutputFieldKinds(p.Format, p.Fields), *topTerms)
+		w = stats
+	} else if format != ESBulk || len(*outputDir) > 0 {
+		if *bulk {
+			log.Fatalln("-bulk cannot be used with -output or -output-dir")
+		}
+		enc, err := NewOutputEncoding(format, action, OutputFieldKinds(p.Format, p.Fields))
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if len(*outputDir) > 0 {
+			w, err = NewShardedWriter(*outputDir, *shardBytes, format, enc)
+			if err != nil {
+				log.Fatalln(err)
+			}
+		} else {
+			w = &StreamWriter{W: os.Stdout, Encoding: enc}
+		}
+	}
+	if *bulk && !collectStats {
+		b := newBulkIndexer(action)
+		if len(*state) > 0 {
+			if len(*deadLetter) > 0 {
+				log.Fatalln("-dead-letter cannot be used with -state, which keeps failed documents itself")
+			}
+			checkpoint, err = OpenCheckpoint(*state, p.Index)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			b.Checkpoint = checkpoint
+			b.DeadLetter = checkpoint.FailedPath()
+			err = ResendFailed(checkpoint, b)
+			if err != nil {
+				log.Fatalln(err)
+			}
+		}
+		w = b
+	} else if len(*state) > 0 {
+		log.Fatalln("-state can only be used with -bulk")
+	}
+
+	// Passages are written to a passage index alongside the document index, the same way as the
+	// documents are.
+	var passages DocumentWriter
+	if len(*passageUnit) > 0 {
+		pa := action
+		pa.Index = *passageIdx
+		if len(pa.Index) == 0 {
+			pa.Index = p.Index + "_passages"
+		}
+		switch {
+		case *bulk:
+			passages = newBulkIndexer(pa)
+		case len(*outputDir) > 0:
+			enc, err := NewOutputEncoding(format, pa, passageFields)
 			if err != nil {
 				log.Fatalln(err)
 			}
+			passages, err = NewShardedWriter(filepath.Join(*outputDir, "passages"), *shardBytes, format, enc)
+			if err != nil {
+				log.Fatalln(err)
+			}
+		case format == ESBulk:
+			passages = StdoutWriter{pa}
+		default:
+			log.Fatalln("-passages can only be written to stdout as es bulk actions, so that they can be told apart from documents")
 		}
-		// NYT (core17)
-	} else if format == NYT {
-		data, id, err := ParseNYT(os.Stdin)
+	}
+
+	if len(*pretokenize) > 0 {
+		a, err := analysis.Named(*pretokenize, *stopwords)
 		if err != nil {
 			log.Fatalln(err)
 		}
-		_, err = os.Stdout.WriteString(fmt.Sprintf(`{ "index": { "_index": "%s", "_id": "%s"  } }
-%s`, collectionName, id, data))
+		w = Pretokenizer{Analyzer: a, Kinds: OutputFieldKinds(p.Format, p.Fields), W: w}
+		if passages != nil {
+			passages = Pretokenizer{Analyzer: a, Kinds: passageFields, W: passages}
+		}
+	}
+	if passages != nil {
+		w, err = NewPassageSplitter(PassageUnit(*passageUnit), *passageLen, *passageStep, OutputFieldKinds(p.Format, p.Fields), passages, w)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if *nearDups {
+		w, err = NewNearDuplicateMarker(OutputFieldKinds(p.Format, p.Fields), *nearBits, *nearReport, w)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if len(p.Fields) > 0 {
+		w = FieldMapper{Fields: p.Fields, W: w}
+	}
+	if len(*duplicates) > 0 {
+		p.Duplicates = DuplicatePolicy(*duplicates)
+	}
+	if len(p.Duplicates) > 0 && p.Format == WashPost {
+		policy, err := ParseDuplicatePolicy(string(p.Duplicates))
+		if err != nil {
+			log.Fatalln(err)
+		}
+		w = &WaPostDeduplicator{Policy: policy, Report: *dupReport, W: w}
+	}
+
+	if len(*root) > 0 {
+		walker := Walker{
+			Root:    *root,
+			Format:  p.Format,
+			Include: p.Include,
+			Exclude: p.Exclude,
+			Workers: *workers,
+			Buffer:  *buffer,
+		}
+		if stats != nil {
+			walker.Done = stats.File
+		}
+		walker.Checkpoint = checkpoint
+		err := walker.Walk(w)
 		if err != nil {
 			log.Fatalln(err)
 		}
 	} else {
-		log.Fatalf("%s is not a known collection format\n", format)
+		pipeline := Pipeline{Workers: *workers, Buffer: *buffer, ChunkBytes: *chunkBytes}
+		_, err := pipeline.ParseStream("-", os.Stdin, p.Format, w)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	err = w.Close()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if checkpoint != nil {
+		err = checkpoint.Close()
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+	err = quarantine.Close()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	err = transcoded.Close()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	entities.LogUnknown()
+
+	if stats != nil {
+		err = stats.Stats.Summary(os.Stdout)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if len(*statsJSON) > 0 {
+			f, err := os.Create(*statsJSON)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			e := json.NewEncoder(f)
+			e.SetIndent("", "  ")
+			err = e.Encode(stats.Stats)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			err = f.Close()
+			if err != nil {
+				log.Fatalln(err)
+			}
+		}
 	}
 }
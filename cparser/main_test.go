@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTRECWEBBodyWithoutText(t *testing.T) {
+	d, err := ParseTRECWEB(strings.NewReader(`<DOC><DOCNO>N1</DOCNO><BODY><HEADLINE>h</HEADLINE></BODY></DOC>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.ID != "N1" || d.Fields["Headline"] != "h" || d.Fields["Text"] != "" {
+		t.Errorf("parsed %+v", d)
+	}
+}
+
+func TestParseNYTParagraphMarkup(t *testing.T) {
+	d, err := ParseNYT(strings.NewReader(`<nitf><head><docdata><doc-id id-string="1"/></docdata></head><body>
+<body.head><abstract><p><person>Jane Doe</person> said so</p></abstract></body.head>
+<body.content><block class="full_text"><p>Full <a href="x">text</a> here &amp; there.</p><p>More <b>text</b>.</p></block></body.content>
+</body></nitf>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := d.Fields["text"]; text != "Full text here & there. More text." {
+		t.Errorf("parsed text %q", text)
+	}
+	if abstract := d.Fields["abstract"]; abstract != "Jane Doe said so" {
+		t.Errorf("parsed abstract %q", abstract)
+	}
+}
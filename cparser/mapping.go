@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// FieldKind is how a document field is indexed.
+type FieldKind string
+
+const (
+	TextField    FieldKind = "text"    // Analysed full text.
+	KeywordField FieldKind = "keyword" // Exact values, e.g., ids and URLs.
+	DateField    FieldKind = "date"    // Dates, either yyyy-MM-dd or RFC 3339.
+	IntegerField FieldKind = "integer" // Whole numbers, e.g., offsets.
+	RawField     FieldKind = "raw"     // Kept in the source of a document, but not indexed.
+)
+
+// Analyzers are the analyzers which text fields can be indexed with, either as the field itself
+// or as a named variant of the field. A nil definition is one of the analyzers built into
+// Elasticsearch.
+var Analyzers = map[string]map[string]interface{}{
+	"english":          nil,
+	WhitespaceAnalyzer: nil, // For pre-tokenized text.
+	"raw": {
+		"type":      "custom",
+		"tokenizer": "standard",
+		"filter":    []string{"lowercase"},
+	},
+	"stopped": {
+		"type":      "custom",
+		"tokenizer": "standard",
+		"filter":    []string{"lowercase", "stop"},
+	},
+	"shingles": {
+		"type":      "custom",
+		"tokenizer": "standard",
+		"filter":    []string{"lowercase", "shingle"},
+	},
+	"porter": {
+		"type":      "custom",
+		"tokenizer": "standard",
+		"filter":    []string{"lowercase", "stop", "porter_stem"},
+	},
+	"krovetz": {
+		"type":      "custom",
+		"tokenizer": "standard",
+		"filter":    []string{"lowercase", "stop", "kstem"},
+	},
+}
+
+// Fields which are not text in each collection format. Any other string field is analysed text.
+var (
+	trecFields = map[string]FieldKind{
+		"DocNo":       KeywordField,
+		"DocType":     KeywordField,
+		"DateTime":    KeywordField,
+		"Date":        KeywordField,
+		"Slug":        KeywordField,
+		"Source":      KeywordField,
+		"Page":        KeywordField,
+		"Publication": KeywordField,
+		"Profile":     KeywordField,
+		"Section":     KeywordField,
+		"Type":        KeywordField,
+		"URL":         KeywordField,
+		"Header":      RawField,
+		"Trailer":     RawField,
+	}
+	webFields = map[string]FieldKind{
+		"DocNo": KeywordField,
+		"URL":   KeywordField,
+	}
+	nytFields = map[string]FieldKind{
+		"id":              KeywordField,
+		"published":       DateField,
+		"url":             KeywordField,
+		"desk":            KeywordField,
+		"online_sections": KeywordField,
+	}
+	wpFields = map[string]FieldKind{
+		"id":             KeywordField,
+		"article_url":    KeywordField,
+		"published_date": DateField,
+		"type":           KeywordField,
+		"source":         KeywordField,
+		"duplicate_ids":  KeywordField,
+	}
+)
+
+// FieldKinds returns how the fields of documents in a collection format are indexed, with the
+// fields renamed (or removed) the same way as the documents of a profile. Fields which are not
+// listed are text.
+func FieldKinds(f Format, rename map[string]string) map[string]FieldKind {
+	fields := make(map[string]FieldKind)
+	for name, kind := range f.Fields {
+		fields[name] = kind
+	}
+	for from, to := range rename {
+		kind, ok := fields[from]
+		if !ok {
+			continue
+		}
+		delete(fields, from)
+		if len(to) > 0 {
+			fields[to] = kind
+		}
+	}
+	// Documents of any format may be marked with their near duplicates.
+	for name, kind := range nearDuplicateFields {
+		fields[name] = kind
+	}
+	return fields
+}
+
+// unknownAnalyzer reports an analyzer which is not one of Analyzers.
+func unknownAnalyzer(name string) error {
+	var names []string
+	for n := range Analyzers {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("%s is not a known analyzer (%s)", name, strings.Join(names, ", "))
+}
+
+// textMapping maps a text field, with a subfield for each of the variants, e.g., text.raw.
+func textMapping(analyzer string, variants []string) map[string]interface{} {
+	m := map[string]interface{}{"type": "text", "analyzer": analyzer}
+	if len(variants) > 0 {
+		fields := make(map[string]interface{})
+		for _, v := range variants {
+			fields[v] = map[string]interface{}{"type": "text", "analyzer": v}
+		}
+		m["fields"] = fields
+	}
+	return m
+}
+
+// Mapping creates the settings and mappings of an index for a collection format. Text fields are
+// indexed with the analyzer, and again as a subfield with each of the variants, which are also
+// named by their analyzer. Fields are renamed (or removed) the same way as the documents of a
+// profile.
+func Mapping(format CollectionFormat, analyzer string, variants []string, shards int, rename map[string]string) (map[string]interface{}, error) {
+	f, err := FormatFor(format)
+	if err != nil {
+		return nil, err
+	}
+	return fieldMapping(string(format), FieldKinds(f, rename), analyzer, variants, shards)
+}
+
+// PassageMapping creates the settings and mappings of an index for the passages of documents.
+// Their text is indexed the same way as the text fields of documents.
+func PassageMapping(analyzer string, variants []string, shards int) (map[string]interface{}, error) {
+	return fieldMapping("passages", passageFields, analyzer, variants, shards)
+}
+
+// fieldMapping creates the settings and mappings of an index of documents with fields of these
+// kinds, named by what they are the documents of.
+func fieldMapping(name string, fields map[string]FieldKind, analyzer string, variants []string, shards int) (map[string]interface{}, error) {
+	analysis := make(map[string]interface{})
+	for _, name := range append([]string{analyzer}, variants...) {
+		def, ok := Analyzers[name]
+		if !ok {
+			return nil, unknownAnalyzer(name)
+		}
+		if def != nil {
+			analysis[name] = def
+		}
+	}
+
+	properties := make(map[string]interface{})
+	for field, kind := range fields {
+		switch kind {
+		case TextField:
+			properties[field] = textMapping(analyzer, variants)
+		case KeywordField, DateField, IntegerField:
+			properties[field] = map[string]interface{}{"type": kind}
+		case RawField:
+			properties[field] = map[string]interface{}{"type": "object", "enabled": false}
+		default:
+			return nil, fmt.Errorf("field %s of %s has unknown kind %s", field, name, kind)
+		}
+	}
+
+	settings := map[string]interface{}{
+		"number_of_shards": shards,
+	}
+	if len(analysis) > 0 {
+		settings["analysis"] = map[string]interface{}{"analyzer": analysis}
+	}
+
+	return map[string]interface{}{
+		"settings": settings,
+		"mappings": map[string]interface{}{
+			// Strings are analysed text, rather than text with a keyword subfield.
+			"dynamic_templates": []interface{}{
+				map[string]interface{}{
+					"strings": map[string]interface{}{
+						"match_mapping_type": "string",
+						"mapping":            textMapping(analyzer, variants),
+					},
+				},
+			},
+			"properties": properties,
+		},
+	}, nil
+}
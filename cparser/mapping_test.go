@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTRECMetadataIsNotText(t *testing.T) {
+	s, _ := TRECSourceFor("FT911-1")
+	fields, err := s.Extract("FT911-1", []byte(`<DOC><DOCNO>FT911-1</DOCNO><PROFILE>_AN-BEOA7AAIFT</PROFILE>
+<DATE>910514</DATE><HEADLINE>Headline</HEADLINE><TEXT>Some text.</TEXT></DOC>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := FieldKinds(formats[TRECTEXT], nil)
+	for _, name := range []string{"Date", "Profile", "Section", "Type"} {
+		if kinds[name] != KeywordField {
+			t.Errorf("%s is mapped as %s, expected a keyword", name, kinds[name])
+		}
+	}
+	if text := Contents(Document{ID: "FT911-1", Fields: fields}, kinds); text != "Headline\nSome text." {
+		t.Errorf("contents are %q", text)
+	}
+}
+
+// mappingValue returns the value at a dot-separated path of a mapping, as it is sent to
+// Elasticsearch.
+func mappingValue(t *testing.T, m map[string]interface{}, path string) interface{} {
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v interface{}
+	err = json.Unmarshal(b, &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range strings.Split(path, ".") {
+		o, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = o[key]
+	}
+	return v
+}
+
+// jsonValue converts a value to how it is decoded from JSON.
+func jsonValue(t *testing.T, v interface{}) interface{} {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var d interface{}
+	err = json.Unmarshal(b, &d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestMapping(t *testing.T) {
+	text := func(analyzer string, variants ...string) map[string]interface{} {
+		m := map[string]interface{}{"type": "text", "analyzer": analyzer}
+		if len(variants) > 0 {
+			fields := make(map[string]interface{})
+			for _, v := range variants {
+				fields[v] = map[string]interface{}{"type": "text", "analyzer": v}
+			}
+			m["fields"] = fields
+		}
+		return m
+	}
+
+	var tests = []struct {
+		format    CollectionFormat
+		analyzer  string
+		variants  []string
+		analyzers []string
+		fields    map[string]interface{}
+	}{
+		{
+			NYT, "porter", []string{"raw", "krovetz"},
+			[]string{"porter", "raw", "krovetz"},
+			map[string]interface{}{
+				"id":         map[string]interface{}{"type": "keyword"},
+				"published":  map[string]interface{}{"type": "date"},
+				"cluster_id": map[string]interface{}{"type": "keyword"},
+			},
+		},
+		{
+			TRECTEXT, "english", []string{"stopped"},
+			[]string{"stopped"},
+			map[string]interface{}{
+				"DocNo":  map[string]interface{}{"type": "keyword"},
+				"Header": map[string]interface{}{"type": "object", "enabled": false},
+			},
+		},
+		{
+			WashPost, "whitespace", nil,
+			nil,
+			map[string]interface{}{
+				"published_date": map[string]interface{}{"type": "date"},
+				"duplicate_ids":  map[string]interface{}{"type": "keyword"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		m, err := Mapping(test.format, test.analyzer, test.variants, 2, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if shards := mappingValue(t, m, "settings.number_of_shards"); shards != float64(2) {
+			t.Errorf("%s has %v shards, expected 2", test.format, shards)
+		}
+
+		// Only the custom analyzers which are used are defined.
+		analyzers := make(map[string]interface{})
+		for _, name := range test.analyzers {
+			analyzers[name] = jsonValue(t, Analyzers[name])
+		}
+		defined, _ := mappingValue(t, m, "settings.analysis.analyzer").(map[string]interface{})
+		if defined == nil {
+			defined = make(map[string]interface{})
+		}
+		if !reflect.DeepEqual(defined, analyzers) {
+			t.Errorf("%s defines the analyzers %v, expected %v", test.format, defined, analyzers)
+		}
+
+		for field, expected := range test.fields {
+			if v := mappingValue(t, m, "mappings.properties."+field); !reflect.DeepEqual(v, jsonValue(t, expected)) {
+				t.Errorf("%s maps %s as %v, expected %v", test.format, field, v, expected)
+			}
+		}
+		// Text fields are mapped by the dynamic template for strings, with a subfield for each variant.
+		templates, _ := mappingValue(t, m, "mappings.dynamic_templates").([]interface{})
+		if len(templates) != 1 {
+			t.Fatalf("%s has the dynamic templates %v, expected one", test.format, templates)
+		}
+		expected := jsonValue(t, text(test.analyzer, test.variants...))
+		if v := templates[0].(map[string]interface{})["strings"].(map[string]interface{})["mapping"]; !reflect.DeepEqual(v, expected) {
+			t.Errorf("%s maps unknown strings as %v, expected %v", test.format, v, expected)
+		}
+	}
+
+	_, err := Mapping(TRECTEXT, "porter", []string{"snowball"}, 1, nil)
+	if err == nil {
+		t.Errorf("snowball is not an analyzer, expected an error")
+	}
+}
+
+func TestMappingRename(t *testing.T) {
+	m, err := Mapping(TRECTEXT, "porter", []string{"raw"}, 1, map[string]string{"DocNo": "docno", "Header": ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := mappingValue(t, m, "mappings.properties.docno.type"); v != "keyword" {
+		t.Errorf("renamed docno is mapped as %v, expected a keyword", v)
+	}
+	for _, field := range []string{"DocNo", "Header"} {
+		if v := mappingValue(t, m, "mappings.properties."+field); v != nil {
+			t.Errorf("%s is mapped as %v, expected it to be renamed or removed", field, v)
+		}
+	}
+}
+
+func TestPassageMapping(t *testing.T) {
+	m, err := PassageMapping("porter", []string{"raw"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		path     string
+		expected interface{}
+	}{
+		{"mappings.properties.text.analyzer", "porter"},
+		{"mappings.properties.text.fields.raw.analyzer", "raw"},
+		{"mappings.properties.docno.type", "keyword"},
+		{"mappings.properties.passage_id.type", "integer"},
+		{"settings.analysis.analyzer.raw.tokenizer", "standard"},
+	}
+	for _, test := range tests {
+		if v := mappingValue(t, m, test.path); v != test.expected {
+			t.Errorf("%s is %v, expected %v", test.path, v, test.expected)
+		}
+	}
+}
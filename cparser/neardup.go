@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+)
+
+// Fields near-duplicate documents are marked with.
+const (
+	SimHashField   = "simhash"
+	ClusterIDField = "cluster_id"
+)
+
+// nearDuplicateFields are indexed as keywords in every collection format, so that search results
+// can be collapsed on their cluster.
+var nearDuplicateFields = map[string]FieldKind{
+	SimHashField:   KeywordField,
+	ClusterIDField: KeywordField,
+}
+
+// nearDuplicateLeader is the first document of a cluster, which later documents are compared to.
+type nearDuplicateLeader struct {
+	id      string
+	print   uint64
+	members []nearDuplicateMember
+}
+
+// nearDuplicateMember is a document which joined a cluster.
+type nearDuplicateMember struct {
+	ID       string `json:"id"`
+	Distance int    `json:"distance"`
+}
+
+// nearDuplicateCluster is written to the report for each cluster with more than one document.
+type nearDuplicateCluster struct {
+	ClusterID string                `json:"cluster_id"`
+	SimHash   string                `json:"simhash"`
+	Size      int                   `json:"size"`
+	Members   []nearDuplicateMember `json:"members"`
+}
+
+// NearDuplicateMarker fingerprints the text of each document using SimHash, and assigns it to a
+// cluster of near duplicates. Both are added to the document, as the simhash and cluster_id fields,
+// before it is written to W. Documents are clustered as they are written rather than being held
+// back: a document joins the first cluster whose first document is within Distance bits of it, and
+// otherwise starts a cluster of its own, named by its id. Documents with fewer than MinWords words
+// are always in a cluster of their own. A report of the clusters with more than one document is
+// written to Report, if set, once the marker is closed.
+type NearDuplicateMarker struct {
+	Kinds    map[string]FieldKind
+	Distance int
+	MinWords int
+	Report   string
+	W        DocumentWriter
+
+	leaders    []nearDuplicateLeader
+	bands      map[uint64][]int
+	duplicates int
+}
+
+// NewNearDuplicateMarker creates a marker which compares the text fields of documents. Only
+// fingerprints which share a band are compared, so the distance can be at most SimHashBands-1.
+func NewNearDuplicateMarker(kinds map[string]FieldKind, distance int, report string, w DocumentWriter) (*NearDuplicateMarker, error) {
+	if distance < 0 || distance > SimHashBands-1 {
+		return nil, fmt.Errorf("near-duplicate distance must be between 0 and %d bits", SimHashBands-1)
+	}
+	return &NearDuplicateMarker{
+		Kinds:    kinds,
+		Distance: distance,
+		MinWords: nearDuplicateMinWords,
+		Report:   report,
+		W:        w,
+		bands:    make(map[uint64][]int),
+	}, nil
+}
+
+// leader finds the first cluster a fingerprint is near, or -1 if there is none.
+func (m *NearDuplicateMarker) leader(f uint64) (int, int) {
+	leader, distance := -1, 0
+	for b := 0; b < SimHashBands; b++ {
+		for _, i := range m.bands[SimHashBand(f, b)] {
+			if leader >= 0 && i >= leader {
+				break
+			}
+			if d := HammingDistance(m.leaders[i].print, f); d <= m.Distance {
+				leader, distance = i, d
+				break
+			}
+		}
+	}
+	return leader, distance
+}
+
+func (m *NearDuplicateMarker) Write(d Document) error {
+	words := Words(Contents(d, m.Kinds))
+	f := SimHash(words, nearDuplicateShingle)
+	cluster := d.ID
+	if len(words) >= m.MinWords {
+		if i, distance := m.leader(f); i >= 0 {
+			cluster = m.leaders[i].id
+			m.leaders[i].members = append(m.leaders[i].members, nearDuplicateMember{ID: d.ID, Distance: distance})
+			m.duplicates++
+		} else {
+			for b := 0; b < SimHashBands; b++ {
+				band := SimHashBand(f, b)
+				m.bands[band] = append(m.bands[band], len(m.leaders))
+			}
+			m.leaders = append(m.leaders, nearDuplicateLeader{id: d.ID, print: f})
+		}
+	}
+
+	if d.Fields == nil {
+		d.Fields = make(map[string]interface{})
+	}
+	d.Fields[SimHashField] = fmt.Sprintf("%016x", f)
+	d.Fields[ClusterIDField] = cluster
+	return m.W.Write(d)
+}
+
+// Close writes the report of the clusters, then closes W.
+func (m *NearDuplicateMarker) Close() error {
+	var clusters int
+	for _, l := range m.leaders {
+		if len(l.members) > 0 {
+			clusters++
+		}
+	}
+	log.Printf("found %d near-duplicate documents in %d clusters\n", m.duplicates, clusters)
+
+	if len(m.Report) > 0 {
+		f, err := os.Create(m.Report)
+		if err != nil {
+			return err
+		}
+		e := json.NewEncoder(f)
+		for _, l := range m.leaders {
+			if len(l.members) == 0 {
+				continue
+			}
+			err = e.Encode(nearDuplicateCluster{
+				ClusterID: l.id,
+				SimHash:   fmt.Sprintf("%016x", l.print),
+				Size:      len(l.members) + 1,
+				Members:   l.members,
+			})
+			if err != nil {
+				f.Close()
+				return err
+			}
+		}
+		err = f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return m.W.Close()
+}
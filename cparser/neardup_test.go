@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestNewNearDuplicateMarkerDistance(t *testing.T) {
+	for distance := -1; distance <= SimHashBands; distance++ {
+		_, err := NewNearDuplicateMarker(nil, distance, "", new(documentCollector))
+		if ok := distance >= 0 && distance < SimHashBands; (err == nil) != ok {
+			t.Errorf("creating a marker with a distance of %d returned %v", distance, err)
+		}
+	}
+}
+
+func TestNearDuplicateMarker(t *testing.T) {
+	var words []string
+	for i := 0; i < 50; i++ {
+		words = append(words, fmt.Sprintf("word%d", i))
+	}
+	text := strings.Join(words, " ")
+
+	c := new(documentCollector)
+	m, err := NewNearDuplicateMarker(nil, 3, "", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []Document{
+		{ID: "a", Fields: map[string]interface{}{"text": text}},
+		{ID: "b", Fields: map[string]interface{}{"text": strings.Replace(text, "word", "other", -1)}},
+		{ID: "c", Fields: map[string]interface{}{"text": strings.ToUpper(text)}},
+		{ID: "d", Fields: map[string]interface{}{"text": "too short"}},
+	} {
+		if err := m.Write(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var clusters []string
+	for _, d := range c.docs {
+		clusters = append(clusters, d.Fields[ClusterIDField].(string))
+	}
+	if got := strings.Join(clusters, ","); got != "a,b,a,d" {
+		t.Errorf("clustered into %s, expected a,b,a,d", got)
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// OutputFormat is the format parsed documents are written in.
+type OutputFormat string
+
+const (
+	ESBulk         OutputFormat = "es"       // Elasticsearch bulk actions.
+	JSONCollection OutputFormat = "jsonl"    // Anserini JsonCollection documents, i.e., {id, contents}.
+	TRECTEXTOutput OutputFormat = "trectext" // TREC documents with a DOCNO and TEXT.
+	SolrJSON       OutputFormat = "solr"     // Solr JSON update documents.
+)
+
+// OutputEncoding describes how documents are written in an output format. Documents in a file are
+// preceded by the header, separated by the separator, and followed by the footer.
+type OutputEncoding struct {
+	ext       string
+	header    string
+	separator string
+	footer    string
+	encode    func(d Document) ([]byte, error)
+}
+
+// NewOutputEncoding creates the encoding for an output format. ES bulk actions are created using
+// the action, and the text of the other formats is made up of the fields which are text.
+func NewOutputEncoding(format OutputFormat, action BulkAction, kinds map[string]FieldKind) (OutputEncoding, error) {
+	switch format {
+	case ESBulk:
+		return OutputEncoding{
+			ext: ".ndjson",
+			encode: func(d Document) ([]byte, error) {
+				a, body, err := action.Encode(d)
+				if err != nil {
+					return nil, err
+				}
+				return []byte(fmt.Sprintf("%s\n%s\n", a, body)), nil
+			},
+		}, nil
+	case JSONCollection:
+		return OutputEncoding{
+			ext: ".jsonl",
+			encode: func(d Document) ([]byte, error) {
+				b, err := json.Marshal(struct {
+					ID       string `json:"id"`
+					Contents string `json:"contents"`
+				}{d.ID, Contents(d, kinds)})
+				return append(b, '\n'), err
+			},
+		}, nil
+	case TRECTEXTOutput:
+		return OutputEncoding{
+			ext: ".trec",
+			encode: func(d Document) ([]byte, error) {
+				var b strings.Builder
+				b.WriteString("<DOC>\n<DOCNO>")
+				writeXMLText(&b, d.ID)
+				b.WriteString("</DOCNO>\n<TEXT>\n")
+				writeXMLText(&b, Contents(d, kinds))
+				b.WriteString("\n</TEXT>\n</DOC>\n")
+				return []byte(b.String()), nil
+			},
+		}, nil
+	case SolrJSON:
+		return OutputEncoding{
+			ext:       ".json",
+			header:    "[\n",
+			separator: ",\n",
+			footer:    "\n]\n",
+			encode: func(d Document) ([]byte, error) {
+				fields := make(map[string]interface{}, len(d.Fields)+1)
+				for k, v := range d.Fields {
+					fields[k] = v
+				}
+				fields["id"] = d.ID
+				return json.Marshal(fields)
+			},
+		}, nil
+	}
+	return OutputEncoding{}, fmt.Errorf("%s is not a known output format (es, jsonl, trectext or solr)", format)
+}
+
+// Contents joins the text fields of a document, i.e., the fields which are strings or lists of
+// strings and are not keywords, dates or raw fields. Fields are joined in order of their name.
+func Contents(d Document, kinds map[string]FieldKind) string {
+	var names []string
+	for name := range d.Fields {
+		if kind, ok := kinds[name]; !ok || kind == TextField {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var text []string
+	for _, name := range names {
+		switch v := d.Fields[name].(type) {
+		case string:
+			if len(v) > 0 {
+				text = append(text, v)
+			}
+		case []interface{}:
+			for _, e := range v {
+				if s, ok := e.(string); ok && len(s) > 0 {
+					text = append(text, s)
+				}
+			}
+		case []string:
+			for _, s := range v {
+				if len(s) > 0 {
+					text = append(text, s)
+				}
+			}
+		}
+	}
+	return strings.Join(text, "\n")
+}
+
+// OutputFieldKinds returns how the fields of a collection format are indexed. Since the auto
+// format may pick any format, it uses the fields of every format.
+func OutputFieldKinds(format CollectionFormat, rename map[string]string) map[string]FieldKind {
+	if f, err := FormatFor(format); err == nil {
+		return FieldKinds(f, rename)
+	}
+	all := Format{Fields: make(map[string]FieldKind)}
+	for _, f := range Formats() {
+		for name, kind := range f.Fields {
+			all.Fields[name] = kind
+		}
+	}
+	return FieldKinds(all, rename)
+}
+
+// StreamWriter writes documents to a single stream, e.g., stdout.
+type StreamWriter struct {
+	W        io.Writer
+	Encoding OutputEncoding
+
+	n int
+}
+
+func (w *StreamWriter) Write(d Document) error {
+	b, err := w.Encoding.encode(d)
+	if err != nil {
+		return err
+	}
+	return w.write(b)
+}
+
+// write writes an encoded document.
+func (w *StreamWriter) write(b []byte) error {
+	sep := w.Encoding.separator
+	if w.n == 0 {
+		sep = w.Encoding.header
+	}
+	w.n++
+	_, err := io.WriteString(w.W, sep)
+	if err != nil {
+		return err
+	}
+	_, err = w.W.Write(b)
+	return err
+}
+
+func (w *StreamWriter) Close() error {
+	if w.n == 0 {
+		_, err := io.WriteString(w.W, w.Encoding.header+w.Encoding.footer)
+		return err
+	}
+	_, err := io.WriteString(w.W, w.Encoding.footer)
+	return err
+}
+
+// Shard is a file written by a ShardedWriter.
+type Shard struct {
+	File      string `json:"file"`
+	Documents int    `json:"documents"`
+	Bytes     int64  `json:"bytes"`
+}
+
+// Manifest lists the files written by a ShardedWriter.
+type Manifest struct {
+	Format    OutputFormat `json:"format"`
+	Documents int          `json:"documents"`
+	Bytes     int64        `json:"bytes"`
+	Shards    []Shard      `json:"shards"`
+}
+
+// ShardedWriter writes documents to numbered files in Dir of at most MaxBytes each, starting a new
+// file rather than writing a document which would take the current one over MaxBytes. A document
+// which is larger than MaxBytes on its own is written to a file of its own. A manifest of the
+// files is written to manifest.json once it is closed.
+type ShardedWriter struct {
+	Dir      string
+	MaxBytes int64
+	Format   OutputFormat
+	Encoding OutputEncoding
+
+	manifest Manifest
+	f        *os.File
+	buf      *bufio.Writer
+	w        *StreamWriter
+	cw       *countingWriter
+}
+
+// countingWriter counts the bytes written to a shard.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// NewShardedWriter creates a sharded writer, creating its directory if necessary.
+func NewShardedWriter(dir string, maxBytes int64, format OutputFormat, enc OutputEncoding) (*ShardedWriter, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &ShardedWriter{
+		Dir:      dir,
+		MaxBytes: maxBytes,
+		Format:   format,
+		Encoding: enc,
+		manifest: Manifest{Format: format},
+	}, nil
+}
+
+func (s *ShardedWriter) Write(d Document) error {
+	b, err := s.Encoding.encode(d)
+	if err != nil {
+		return err
+	}
+	if s.f != nil && s.MaxBytes > 0 {
+		size := s.cw.n + int64(len(s.Encoding.separator)+len(b)+len(s.Encoding.footer))
+		if size > s.MaxBytes {
+			err = s.closeShard()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.f == nil {
+		name := fmt.Sprintf("docs-%05d%s", len(s.manifest.Shards), s.Encoding.ext)
+		f, err := os.Create(filepath.Join(s.Dir, name))
+		if err != nil {
+			return err
+		}
+		s.f = f
+		s.buf = bufio.NewWriter(f)
+		s.cw = &countingWriter{w: s.buf}
+		s.w = &StreamWriter{W: s.cw, Encoding: s.Encoding}
+		s.manifest.Shards = append(s.manifest.Shards, Shard{File: name})
+	}
+	err = s.w.write(b)
+	if err != nil {
+		return err
+	}
+	s.manifest.Shards[len(s.manifest.Shards)-1].Documents++
+	s.manifest.Documents++
+	return nil
+}
+
+// closeShard finishes the current file.
+func (s *ShardedWriter) closeShard() error {
+	err := s.w.Close()
+	if err != nil {
+		return err
+	}
+	err = s.buf.Flush()
+	if err != nil {
+		return err
+	}
+	shard := &s.manifest.Shards[len(s.manifest.Shards)-1]
+	shard.Bytes = s.cw.n
+	s.manifest.Bytes += s.cw.n
+	err = s.f.Close()
+	s.f = nil
+	return err
+}
+
+// Close finishes the current file and writes the manifest.
+func (s *ShardedWriter) Close() error {
+	if s.f != nil {
+		err := s.closeShard()
+		if err != nil {
+			return err
+		}
+	}
+	f, err := os.Create(filepath.Join(s.Dir, "manifest.json"))
+	if err != nil {
+		return err
+	}
+	e := json.NewEncoder(f)
+	e.SetIndent("", "  ")
+	err = e.Encode(s.manifest)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
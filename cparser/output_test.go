@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOutputEncoding(t *testing.T) {
+	kinds := map[string]FieldKind{"title": TextField, "text": TextField, "url": KeywordField}
+	docs := []Document{
+		{ID: "FT-1", Fields: map[string]interface{}{"title": "A & B", "text": "x < y", "url": "http://a"}},
+		{ID: "FT-2", Fields: map[string]interface{}{"text": []interface{}{"one", "", "two"}}},
+	}
+
+	var tests = []struct {
+		format   OutputFormat
+		docs     []Document
+		expected string
+	}{
+		{
+			JSONCollection,
+			docs,
+			`{"id":"FT-1","contents":"x \u003c y\nA \u0026 B"}` + "\n" +
+				`{"id":"FT-2","contents":"one\ntwo"}` + "\n",
+		},
+		{
+			TRECTEXTOutput,
+			docs,
+			"<DOC>\n<DOCNO>FT-1</DOCNO>\n<TEXT>\nx &lt; y\nA &amp; B\n</TEXT>\n</DOC>\n" +
+				"<DOC>\n<DOCNO>FT-2</DOCNO>\n<TEXT>\none\ntwo\n</TEXT>\n</DOC>\n",
+		},
+		{
+			SolrJSON,
+			docs,
+			"[\n" +
+				`{"id":"FT-1","text":"x \u003c y","title":"A \u0026 B","url":"http://a"}` + ",\n" +
+				`{"id":"FT-2","text":["one","","two"]}` + "\n]\n",
+		},
+		{
+			SolrJSON,
+			nil,
+			"[\n\n]\n",
+		},
+		{
+			JSONCollection,
+			nil,
+			"",
+		},
+	}
+
+	for _, test := range tests {
+		enc, err := NewOutputEncoding(test.format, BulkAction{}, kinds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b bytes.Buffer
+		w := &StreamWriter{W: &b, Encoding: enc}
+		for _, d := range test.docs {
+			err = w.Write(d)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err = w.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != test.expected {
+			t.Errorf("%s wrote %q, expected %q", test.format, b.String(), test.expected)
+		}
+		if test.format == SolrJSON {
+			var v []map[string]interface{}
+			if err := json.Unmarshal(b.Bytes(), &v); err != nil {
+				t.Errorf("%s wrote invalid JSON %q: %v", test.format, b.String(), err)
+			}
+		}
+	}
+
+	_, err := NewOutputEncoding("csv", BulkAction{}, kinds)
+	if err == nil {
+		t.Errorf("csv is not an output format, expected an error")
+	}
+}
+
+func TestShardedWriter(t *testing.T) {
+	kinds := map[string]FieldKind{"text": TextField}
+	var tests = []struct {
+		format   OutputFormat
+		maxBytes int64
+		texts    []string
+		shards   []int
+	}{
+		// Each jsonl document is 33 bytes, so two fit in 70 bytes but three do not.
+		{JSONCollection, 70, []string{"aaaa", "bbbb", "cccc", "dddd", "eeee"}, []int{2, 2, 1}},
+		// Exactly filling a shard does not start a new one.
+		{JSONCollection, 66, []string{"aaaa", "bbbb", "cccc"}, []int{2, 1}},
+		// A document larger than a shard gets one of its own.
+		{JSONCollection, 40, []string{"aaaa", strings.Repeat("x", 50), "cccc"}, []int{1, 1, 1}},
+		// The header, separators and footer count towards the size of a shard.
+		{SolrJSON, 63, []string{"aaaa", "bbbb", "cccc"}, []int{2, 1}},
+		{TRECTEXTOutput, 150, []string{"a < b", "cccc", "dddd"}, []int{2, 1}},
+		{JSONCollection, 0, []string{"aaaa", "bbbb", "cccc"}, []int{3}},
+	}
+
+	for _, test := range tests {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+
+		enc, err := NewOutputEncoding(test.format, BulkAction{}, kinds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err := NewShardedWriter(dir, test.maxBytes, test.format, enc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, text := range test.texts {
+			err = w.Write(Document{ID: "doc-" + string('0'+rune(i)), Fields: map[string]interface{}{"text": text}})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err = w.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := ioutil.ReadFile(filepath.Join(dir, "manifest.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var m Manifest
+		err = json.Unmarshal(b, &m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Format != test.format || m.Documents != len(test.texts) {
+			t.Errorf("%s manifest has format %s and %d documents, expected %d", test.format, m.Format, m.Documents, len(test.texts))
+		}
+		if len(m.Shards) != len(test.shards) {
+			t.Errorf("%s wrote %d shards (%v), expected %d", test.format, len(m.Shards), m.Shards, len(test.shards))
+			continue
+		}
+		var total int64
+		for i, shard := range m.Shards {
+			fi, err := os.Stat(filepath.Join(dir, shard.File))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if shard.Bytes != fi.Size() {
+				t.Errorf("%s manifest has %d bytes for %s, expected %d", test.format, shard.Bytes, shard.File, fi.Size())
+			}
+			if shard.Documents != test.shards[i] {
+				t.Errorf("%s wrote %d documents to %s, expected %d", test.format, shard.Documents, shard.File, test.shards[i])
+			}
+			if test.maxBytes > 0 && shard.Documents > 1 && shard.Bytes > test.maxBytes {
+				t.Errorf("%s wrote %d bytes to %s, expected at most %d", test.format, shard.Bytes, shard.File, test.maxBytes)
+			}
+			total += shard.Bytes
+		}
+		if m.Bytes != total {
+			t.Errorf("%s manifest has %d bytes, expected %d", test.format, m.Bytes, total)
+		}
+		if ext := filepath.Ext(m.Shards[0].File); ext != enc.ext {
+			t.Errorf("%s wrote %s, expected the extension %s", test.format, m.Shards[0].File, enc.ext)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// PassageUnit is what the passages of a document are made of.
+type PassageUnit string
+
+const (
+	WordPassages      PassageUnit = "words"
+	SentencePassages  PassageUnit = "sentences"
+	ParagraphPassages PassageUnit = "paragraphs"
+)
+
+// Fields of passage documents.
+const (
+	PassageDocNoField = "docno"
+	PassageIDField    = "passage_id"
+	PassageStartField = "start_offset"
+	PassageEndField   = "end_offset"
+	PassageTextField  = "text"
+)
+
+// passageFields are how the fields of passage documents are indexed.
+var passageFields = map[string]FieldKind{
+	PassageDocNoField: KeywordField,
+	PassageIDField:    IntegerField,
+	PassageStartField: IntegerField,
+	PassageEndField:   IntegerField,
+	PassageTextField:  TextField,
+}
+
+// passageSizes are the default number of units in a passage.
+var passageSizes = map[PassageUnit]int{
+	WordPassages:      150,
+	SentencePassages:  4,
+	ParagraphPassages: 1,
+}
+
+// passageSeparators find the ends of units. A unit ends at the end of its separator, so that
+// sentences keep their full stop.
+var passageSeparators = map[PassageUnit]*regexp.Regexp{
+	WordPassages:      regexp.MustCompile(`\s+`),
+	SentencePassages:  regexp.MustCompile(`[.!?]+["')\]]*\s+|\n[ \t\r]*\n\s*`),
+	ParagraphPassages: regexp.MustCompile(`\n[ \t\r]*\n\s*`),
+}
+
+// Passage is a passage of text, from its Start byte to its End byte.
+type Passage struct {
+	Start int
+	End   int
+}
+
+// units finds the units of text, without the whitespace around them.
+func units(text string, sep *regexp.Regexp) []Passage {
+	var (
+		spans []Passage
+		start int
+	)
+	add := func(end int) {
+		s := text[start:end]
+		trimmed := strings.TrimLeft(s, " \t\r\n\f\v")
+		begin := start + len(s) - len(trimmed)
+		trimmed = strings.TrimRight(trimmed, " \t\r\n\f\v")
+		if len(trimmed) > 0 {
+			spans = append(spans, Passage{Start: begin, End: begin + len(trimmed)})
+		}
+	}
+	for _, m := range sep.FindAllStringIndex(text, -1) {
+		add(m[1])
+		start = m[1]
+	}
+	add(len(text))
+	return spans
+}
+
+// SplitPassages splits text into passages of size units (words, sentences or paragraphs), which
+// start every stride units. Words are separated by whitespace, sentences end with a full stop,
+// question mark or exclamation mark followed by whitespace, and paragraphs are separated by blank
+// lines. The last passage ends at the end of the text, so it may be shorter.
+func SplitPassages(text string, unit PassageUnit, size, stride int) []Passage {
+	sep, ok := passageSeparators[unit]
+	if !ok || size < 1 || stride < 1 {
+		return nil
+	}
+	spans := units(text, sep)
+	var passages []Passage
+	for i := 0; i < len(spans); i += stride {
+		j := i + size
+		if j > len(spans) {
+			j = len(spans)
+		}
+		passages = append(passages, Passage{Start: spans[i].Start, End: spans[j-1].End})
+		if j == len(spans) {
+			break
+		}
+	}
+	return passages
+}
+
+// PassageSplitter splits the text of each document into passages, which are written to Passages
+// as documents of their own, while the document itself is written to W. A passage is identified
+// by the id of its document and its position in the document, e.g., FBIS3-1#0, and records the
+// byte offsets of its text in the contents of the document, i.e., its text fields joined in order
+// of their name (see Contents).
+type PassageSplitter struct {
+	Unit     PassageUnit
+	Size     int
+	Stride   int
+	Kinds    map[string]FieldKind
+	Passages DocumentWriter
+	W        DocumentWriter
+
+	documents int
+	passages  int
+}
+
+// NewPassageSplitter creates a splitter of passages of size units, starting every stride units.
+// A size or stride of 0 is the default for the unit: 150 words, 4 sentences or 1 paragraph,
+// starting every half passage.
+func NewPassageSplitter(unit PassageUnit, size, stride int, kinds map[string]FieldKind, passages, w DocumentWriter) (*PassageSplitter, error) {
+	def, ok := passageSizes[unit]
+	if !ok {
+		return nil, fmt.Errorf("%s is not a known passage unit (words, sentences or paragraphs)", unit)
+	}
+	if size < 0 || stride < 0 {
+		return nil, fmt.Errorf("passage size and stride must be positive")
+	}
+	if size == 0 {
+		size = def
+	}
+	if stride == 0 {
+		stride = size / 2
+		if stride < 1 {
+			stride = 1
+		}
+	}
+	return &PassageSplitter{
+		Unit:     unit,
+		Size:     size,
+		Stride:   stride,
+		Kinds:    kinds,
+		Passages: passages,
+		W:        w,
+	}, nil
+}
+
+func (s *PassageSplitter) Write(d Document) error {
+	// The text is taken before the document is written, since later writers may change its fields.
+	text := Contents(d, s.Kinds)
+	err := s.W.Write(d)
+	if err != nil {
+		return err
+	}
+	s.documents++
+
+	for i, p := range SplitPassages(text, s.Unit, s.Size, s.Stride) {
+		err = s.Passages.Write(Document{
+			ID: fmt.Sprintf("%s#%d", d.ID, i),
+			Fields: map[string]interface{}{
+				PassageDocNoField: d.ID,
+				PassageIDField:    i,
+				PassageStartField: p.Start,
+				PassageEndField:   p.End,
+				PassageTextField:  text[p.Start:p.End],
+			},
+		})
+		if err != nil {
+			return err
+		}
+		s.passages++
+	}
+	return nil
+}
+
+// Close closes both the passage writer and W.
+func (s *PassageSplitter) Close() error {
+	log.Printf("split %d documents into %d passages\n", s.documents, s.passages)
+	err := s.Passages.Close()
+	if werr := s.W.Close(); err == nil {
+		err = werr
+	}
+	return err
+}
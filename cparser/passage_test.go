@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// passageTexts returns the text of each passage.
+func passageTexts(text string, passages []Passage) []string {
+	var texts []string
+	for _, p := range passages {
+		texts = append(texts, text[p.Start:p.End])
+	}
+	return texts
+}
+
+func TestSplitPassagesWords(t *testing.T) {
+	text := "  one two\tthree\n\nfour five  "
+	for _, c := range []struct {
+		size, stride int
+		passages     []string
+	}{
+		{1, 1, []string{"one", "two", "three", "four", "five"}},
+		{2, 1, []string{"one two", "two\tthree", "three\n\nfour", "four five"}},
+		{2, 2, []string{"one two", "three\n\nfour", "five"}},
+		{2, 3, []string{"one two", "four five"}},
+		{3, 2, []string{"one two\tthree", "three\n\nfour five"}},
+		{5, 1, []string{"one two\tthree\n\nfour five"}}, // The first passage reaches the end, so it is the last.
+		{10, 4, []string{"one two\tthree\n\nfour five"}},
+		{1, 10, []string{"one"}},
+		{0, 1, nil},
+		{1, 0, nil},
+	} {
+		got := passageTexts(text, SplitPassages(text, WordPassages, c.size, c.stride))
+		if !reflect.DeepEqual(got, c.passages) {
+			t.Errorf("size %d, stride %d: %q, expected %q", c.size, c.stride, got, c.passages)
+		}
+	}
+}
+
+func TestSplitPassagesSentencesAndParagraphs(t *testing.T) {
+	text := "First sentence. Is this the second? Yes! \"Quoted.\" Last one\n\n  New paragraph.\n \nThird"
+	got := passageTexts(text, SplitPassages(text, SentencePassages, 2, 2))
+	expected := []string{
+		"First sentence. Is this the second?",
+		"Yes! \"Quoted.\"",
+		"Last one\n\n  New paragraph.", // A paragraph break ends a sentence.
+		"Third",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("sentence passages %q, expected %q", got, expected)
+	}
+
+	got = passageTexts(text, SplitPassages(text, ParagraphPassages, 1, 1))
+	expected = []string{
+		"First sentence. Is this the second? Yes! \"Quoted.\" Last one",
+		"New paragraph.",
+		"Third",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("paragraph passages %q, expected %q", got, expected)
+	}
+}
+
+func TestSplitPassagesEmpty(t *testing.T) {
+	for _, text := range []string{"", " \n\n\t "} {
+		for unit := range passageSeparators {
+			if p := SplitPassages(text, unit, 2, 1); len(p) != 0 {
+				t.Errorf("%q was split into %d %s passages", text, len(p), unit)
+			}
+		}
+	}
+	if p := SplitPassages("some words", "lines", 1, 1); p != nil {
+		t.Errorf("an unknown unit was split into %v", p)
+	}
+}
+
+func TestNewPassageSplitter(t *testing.T) {
+	for _, c := range []struct {
+		unit                         PassageUnit
+		size, stride                 int
+		expectedSize, expectedStride int
+	}{
+		{WordPassages, 0, 0, 150, 75},
+		{SentencePassages, 0, 0, 4, 2},
+		{ParagraphPassages, 0, 0, 1, 1}, // Half of one passage is still one.
+		{WordPassages, 10, 0, 10, 5},
+		{WordPassages, 10, 3, 10, 3},
+	} {
+		s, err := NewPassageSplitter(c.unit, c.size, c.stride, nil, new(documentCollector), new(documentCollector))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Size != c.expectedSize || s.Stride != c.expectedStride {
+			t.Errorf("%s passages of %d, stride %d: size %d, stride %d", c.unit, c.size, c.stride, s.Size, s.Stride)
+		}
+	}
+	for _, c := range []struct {
+		unit         PassageUnit
+		size, stride int
+	}{{"lines", 1, 1}, {WordPassages, -1, 0}, {WordPassages, 0, -1}} {
+		if _, err := NewPassageSplitter(c.unit, c.size, c.stride, nil, nil, nil); err == nil {
+			t.Errorf("%s passages of %d, stride %d did not return an error", c.unit, c.size, c.stride)
+		}
+	}
+}
+
+func TestPassageSplitterOffsets(t *testing.T) {
+	var words []string
+	for i := 0; i < 25; i++ {
+		words = append(words, strings.Repeat("w", i%4+1))
+	}
+	d := Document{ID: "D1", Fields: map[string]interface{}{
+		"b_text":  strings.Join(words[:10], " "),
+		"a_title": strings.Join(words[10:], "\n"),
+		"id":      "D1",
+	}}
+	kinds := map[string]FieldKind{"id": KeywordField}
+
+	docs, passages := new(documentCollector), new(documentCollector)
+	s, err := NewPassageSplitter(WordPassages, 6, 4, kinds, passages, docs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Write(d); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(docs.docs) != 1 || !docs.closed || !passages.closed {
+		t.Fatalf("wrote %d documents", len(docs.docs))
+	}
+
+	// The offsets of each passage are of its text in the contents of the document.
+	contents := Contents(d, kinds)
+	if len(passages.docs) != 6 {
+		t.Errorf("split 25 words into %d passages, expected 6", len(passages.docs))
+	}
+	for i, p := range passages.docs {
+		f := p.Fields
+		start, end := f[PassageStartField].(int), f[PassageEndField].(int)
+		if contents[start:end] != f[PassageTextField] {
+			t.Errorf("passage %d is %q, but its offsets are of %q", i, f[PassageTextField], contents[start:end])
+		}
+		if n := len(strings.Fields(contents[start:end])); n != 6 && i < 5 {
+			t.Errorf("passage %d has %d words, expected 6", i, n)
+		}
+		if p.ID != fmt.Sprintf("D1#%d", i) || f[PassageDocNoField] != "D1" || f[PassageIDField] != i {
+			t.Errorf("passage %d is %s of %v", i, p.ID, f[PassageDocNoField])
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+)
+
+// ParseJob is a unit of work for a Pipeline, e.g., a collection file or a chunk of a stream.
+type ParseJob struct {
+	Name  string
+	Parse func(w DocumentWriter) (int, error)
+}
+
+// pipelineJob is a job which has been handed to a worker, along with its buffered documents.
+type pipelineJob struct {
+	ParseJob
+	docs chan Document
+	res  chan parseResult
+}
+
+// parseResult is the outcome of a job.
+type parseResult struct {
+	docs int
+	err  error
+}
+
+// errPipelineStopped is returned to the parsers of a pipeline which has stopped.
+var errPipelineStopped = errors.New("pipeline stopped")
+
+// chanWriter hands the documents of a job to the pipeline.
+type chanWriter struct {
+	docs chan<- Document
+	quit <-chan struct{}
+}
+
+func (c chanWriter) Write(d Document) error {
+	select {
+	case c.docs <- d:
+		return nil
+	case <-c.quit:
+		return errPipelineStopped
+	}
+}
+
+func (c chanWriter) Close() error {
+	return nil
+}
+
+// Pipeline parses jobs concurrently using a pool of Workers, and writes their documents in the
+// order the jobs were submitted, so the output is the same however many workers are used. Each
+// job buffers up to Buffer documents. A worker which is ahead of the writer waits once its buffer
+// is full, so only a few jobs are held in memory at once, and a slow writer (e.g., a bulk indexer
+// waiting for Elasticsearch) slows down the workers rather than documents piling up.
+type Pipeline struct {
+	Workers int
+	Buffer  int
+
+	// ChunkBytes is the size of the chunks a stream is cut into.
+	ChunkBytes int
+}
+
+// Run parses each job sent on jobs and writes the documents to w, calling done with the outcome
+// of each job in order. A job whose documents cannot be written fails with the write error. If
+// done returns an error, the pipeline stops and Run returns it.
+func (p Pipeline) Run(jobs <-chan ParseJob, w DocumentWriter, done func(name string, docs int, err error) error) error {
+	workers := p.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	buffer := p.Buffer
+	if buffer < 1 {
+		buffer = 1
+	}
+
+	var (
+		quit  = make(chan struct{})
+		work  = make(chan *pipelineJob)
+		order = make(chan *pipelineJob, workers)
+		wg    sync.WaitGroup
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range work {
+				n, err := j.Parse(chanWriter{docs: j.docs, quit: quit})
+				close(j.docs)
+				j.res <- parseResult{docs: n, err: err}
+			}
+		}()
+	}
+
+	// Jobs are queued for the writer before they are handed to a worker, so the writer is never
+	// waiting for a job which no worker has picked up.
+	go func() {
+		defer close(order)
+		defer close(work)
+		for job := range jobs {
+			j := &pipelineJob{
+				ParseJob: job,
+				docs:     make(chan Document, buffer),
+				res:      make(chan parseResult, 1),
+			}
+			select {
+			case order <- j:
+			case <-quit:
+				for range jobs {
+				}
+				return
+			}
+			select {
+			case work <- j:
+			case <-quit:
+				for range jobs {
+				}
+				return
+			}
+		}
+	}()
+
+	var err error
+	for j := range order {
+		var werr error
+		for d := range j.docs {
+			if werr == nil {
+				werr = w.Write(d)
+			}
+		}
+		res := <-j.res
+		if res.err == nil {
+			res.err = werr
+		}
+		err = done(j.Name, res.docs, res.err)
+		if err != nil {
+			close(quit)
+			break
+		}
+	}
+	wg.Wait()
+	return err
+}
+
+// chunk is a piece of a stream which is parsed on its own. Parsers which record the offsets of
+// documents start counting from the offset of the chunk.
+type chunk struct {
+	*bytes.Reader
+	offset int64
+}
+
+// streamOffset is the offset in a stream that r starts at.
+func streamOffset(r io.Reader) int64 {
+	if c, ok := r.(*chunk); ok {
+		return c.offset
+	}
+	return 0
+}
+
+// ParseStream parses a stream (e.g., stdin) of a collection format, decompressing it if
+// necessary. When the format can be split, the stream is cut into chunks of whole documents which
+// are parsed concurrently; otherwise it is parsed the same way as ParseCompressed.
+func (p Pipeline) ParseStream(name string, r io.Reader, format CollectionFormat, w DocumentWriter) (int, error) {
+	f := format
+	if f == Auto {
+		f = FormatForFile(name)
+	}
+	if c, err := FormatFor(f); p.Workers <= 1 || (err == nil && c.Compressed) {
+		return ParseCompressed(name, r, format, w)
+	}
+
+	var (
+		jobs = make(chan ParseJob)
+		stop = make(chan struct{}) // Closed when the pipeline stops, so no more jobs are sent.
+		rerr = make(chan error, 1)
+		n    int
+	)
+	go func() {
+		defer close(jobs)
+		rerr <- Decompress(name, r, func(name string, r io.Reader) error {
+			f := format
+			if f == Auto {
+				f = FormatForFile(name)
+			}
+			c, err := FormatFor(f)
+			if err != nil {
+				return err
+			}
+			if c.Split == nil {
+				return parseEntry(name, r, c, jobs, stop)
+			}
+			return p.chunks(name, r, c, jobs, stop)
+		})
+	}()
+
+	err := p.Run(jobs, w, func(_ string, docs int, err error) error {
+		n += docs
+		if err != nil {
+			close(stop)
+		}
+		return err
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, <-rerr
+}
+
+// parseEntry sends a job to parse a whole file of a stream, and waits for it to be parsed, since
+// the file can only be read until it returns. If the pipeline stops first, the job is abandoned
+// unless a worker has already started reading the file, in which case it still waits for it.
+func parseEntry(name string, r io.Reader, c Format, jobs chan<- ParseJob, stop <-chan struct{}) error {
+	var (
+		parsed = make(chan struct{})
+		claim  = make(chan struct{}, 1) // Taken by whichever of the worker and the stream is first.
+	)
+	claim <- struct{}{}
+	job := ParseJob{Name: name, Parse: func(w DocumentWriter) (int, error) {
+		defer close(parsed)
+		select {
+		case <-claim:
+		default:
+			return 0, errPipelineStopped
+		}
+		return c.Parse(name, r, w)
+	}}
+
+	select {
+	case jobs <- job:
+	case <-stop:
+		return errPipelineStopped
+	}
+	select {
+	case <-parsed:
+		return nil
+	case <-stop:
+	}
+	select {
+	case <-claim:
+	default:
+		<-parsed
+	}
+	return errPipelineStopped
+}
+
+// chunks cuts a stream into chunks of about ChunkBytes which end at the end of a document, and
+// sends a job to parse each one. A document larger than ChunkBytes is kept whole.
+func (p Pipeline) chunks(name string, r io.Reader, c Format, jobs chan<- ParseJob, stop <-chan struct{}) error {
+	size := p.ChunkBytes
+	if size < 1 {
+		size = 4 << 20
+	}
+	var (
+		offset int64
+		rest   []byte
+	)
+	for {
+		data := make([]byte, len(rest)+size)
+		copy(data, rest)
+		m, err := io.ReadFull(r, data[len(rest):])
+		data = data[:len(rest)+m]
+		eof := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !eof {
+			return err
+		}
+
+		cut := len(data)
+		if !eof {
+			cut = c.Split(data)
+		}
+		rest = data[cut:]
+		if cut > 0 {
+			data, start := data[:cut], offset
+			job := ParseJob{Name: name, Parse: func(w DocumentWriter) (int, error) {
+				return c.Parse(name, &chunk{Reader: bytes.NewReader(data), offset: start}, w)
+			}}
+			select {
+			case jobs <- job:
+			case <-stop:
+				return errPipelineStopped
+			}
+			offset += int64(cut)
+		}
+		if eof {
+			return nil
+		}
+	}
+}
+
+// splitTREC finds the end of the last </DOC> tag in data. A TREC tokenizer is always between
+// documents after a </DOC> tag, whether or not it was reading a document.
+func splitTREC(data []byte) int {
+	i := bytes.LastIndex(data, []byte(EndToken))
+	if i < 0 {
+		return 0
+	}
+	return i + len(EndToken)
+}
+
+// splitLines finds the end of the last line in data.
+func splitLines(data []byte) int {
+	return bytes.LastIndexByte(data, '\n') + 1
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// tarFiles creates a tar archive of files.
+func tarFiles(t *testing.T, files ...[2]string) []byte {
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	for _, f := range files {
+		err := tw.WriteHeader(&tar.Header{Name: f[0], Mode: 0644, Size: int64(len(f[1])), Typeflag: tar.TypeReg})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(f[1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestParseStreamStopsOnError(t *testing.T) {
+	files := [][2]string{{"bad.xml", "<nitf><head>"}}
+	for i := 0; i < 20; i++ {
+		files = append(files, [2]string{
+			fmt.Sprintf("%d.xml", i),
+			fmt.Sprintf(`<nitf><head><docdata><doc-id id-string="%d"/></docdata></head></nitf>`, i),
+		})
+	}
+	data := tarFiles(t, files...)
+
+	for _, workers := range []int{2, 4} {
+		errs := make(chan error, 1)
+		go func() {
+			_, err := Pipeline{Workers: workers, Buffer: 1}.ParseStream("stdin", bytes.NewReader(data), NYT, new(documentCollector))
+			errs <- err
+		}()
+		select {
+		case err := <-errs:
+			if err == nil {
+				t.Errorf("parsing a stream with a malformed file using %d workers succeeded", workers)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("parsing a stream with a malformed file using %d workers did not stop", workers)
+		}
+	}
+}
+
+// failingWriter fails to write any document.
+type failingWriter struct{}
+
+func (failingWriter) Write(d Document) error {
+	return fmt.Errorf("cannot write %s", d.ID)
+}
+
+func (failingWriter) Close() error {
+	return nil
+}
+
+func TestParseStreamStopsOnWriteError(t *testing.T) {
+	var b bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&b, "<DOC>\n<DOCNO>D%d</DOCNO>\n<TEXT>Some text.</TEXT>\n</DOC>\n", i)
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := Pipeline{Workers: 4, Buffer: 1, ChunkBytes: 256}.ParseStream("stdin", &b, TRECTEXT, failingWriter{})
+		errs <- err
+	}()
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Error("parsing a stream whose documents cannot be written succeeded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("parsing a stream whose documents cannot be written did not stop")
+	}
+}
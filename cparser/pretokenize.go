@@ -0,0 +1,57 @@
+package main
+
+import (
+	"github.com/osirrc2019/ielab-docker/cparser/analysis"
+	"strings"
+)
+
+// WhitespaceAnalyzer is the analyzer which text fields are indexed with once they have been
+// pre-tokenized, so that Elasticsearch indexes exactly the terms cparser produced.
+const WhitespaceAnalyzer = "whitespace"
+
+// Pretokenizer analyses the text fields of documents with Analyzer before writing them to W,
+// replacing each with its terms separated by spaces. Fields which are not listed in Kinds are
+// text. Lists of strings are analysed element by element.
+type Pretokenizer struct {
+	Analyzer analysis.Analyzer
+	Kinds    map[string]FieldKind
+	W        DocumentWriter
+}
+
+func (p Pretokenizer) Write(d Document) error {
+	for name, v := range d.Fields {
+		if kind, ok := p.Kinds[name]; ok && kind != TextField {
+			continue
+		}
+		switch v := v.(type) {
+		case string:
+			d.Fields[name] = p.analyze(v)
+		case []interface{}:
+			terms := make([]interface{}, len(v))
+			for i, e := range v {
+				if s, ok := e.(string); ok {
+					terms[i] = p.analyze(s)
+				} else {
+					terms[i] = e
+				}
+			}
+			d.Fields[name] = terms
+		case []string:
+			terms := make([]string, len(v))
+			for i, s := range v {
+				terms[i] = p.analyze(s)
+			}
+			d.Fields[name] = terms
+		}
+	}
+	return p.W.Write(d)
+}
+
+// analyze turns text into its terms separated by spaces.
+func (p Pretokenizer) analyze(text string) string {
+	return strings.Join(p.Analyzer.Analyze(text), " ")
+}
+
+func (p Pretokenizer) Close() error {
+	return p.W.Close()
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// Profile describes how a collection is parsed and indexed. Profiles are loaded from JSON files,
+// or one of the built-in profiles can be used by name.
+type Profile struct {
+	Index     string            `json:"index"`
+	Format    CollectionFormat  `json:"format"`
+	Include   []string          `json:"include,omitempty"`
+	Exclude   []string          `json:"exclude,omitempty"`
+	BulkDocs  int               `json:"bulk_docs,omitempty"`
+	BulkBytes int               `json:"bulk_bytes,omitempty"`
+	Fields    map[string]string `json:"fields,omitempty"`
+	Variants  []string          `json:"variants,omitempty"`
+
+	// Duplicates is the policy for duplicate articles in a Washington Post collection.
+	Duplicates DuplicatePolicy `json:"duplicates,omitempty"`
+}
+
+// Profiles are the built-in collection profiles.
+var Profiles = map[string]Profile{
+	"robust04": {
+		Index:  "robust04",
+		Format: TRECTEXT,
+		// Remove the unwanted parts of disk45 (as per ROBUST04 guidelines).
+		Exclude: []string{
+			"disk4/cr",
+			"disk4/dtds",
+			"disk5/dtds",
+			"disk4/fr94/aux",
+			"disk4/ft/readfrcg.z",
+			"disk4/ft/readmeft.z",
+			"disk4/fr94/readchg.z",
+			"disk4/fr94/readmefr.z",
+			"disk5/latimes/readmela.txt",
+			"disk5/latimes/readchg.txt",
+		},
+		BulkDocs:  1000,
+		BulkBytes: 5 << 20,
+		Variants:  []string{"raw", "krovetz"},
+	},
+	"core17": {
+		Index:     "core17",
+		Format:    NYT,
+		Exclude:   []string{"docs", "dtd", "tools", "index.html"},
+		BulkDocs:  1000,
+		BulkBytes: 5 << 20,
+		Variants:  []string{"raw", "krovetz"},
+	},
+	"core18": {
+		Index:      "core18",
+		Format:     WashPost,
+		Exclude:    []string{"MD5SUMS", "README.md", "scripts"},
+		BulkDocs:   1000,
+		BulkBytes:  5 << 20,
+		Variants:   []string{"raw", "krovetz"},
+		Duplicates: LatestWins,
+	},
+	"cw12b": {
+		Index:     "cw12b",
+		Format:    WARC,
+		Include:   []string{"*.warc", "*.warc.gz"},
+		BulkDocs:  500,
+		BulkBytes: 10 << 20,
+	},
+}
+
+// LoadProfile loads a built-in profile by name, or otherwise a profile from a JSON file.
+func LoadProfile(name string) (Profile, error) {
+	if p, ok := Profiles[name]; ok {
+		return p, nil
+	}
+
+	var p Profile
+	b, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return p, fmt.Errorf("%s is not a built-in profile or a profile file", name)
+	}
+	if err != nil {
+		return p, err
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	err = d.Decode(&p)
+	if err != nil {
+		return p, fmt.Errorf("%s: %v", name, err)
+	}
+	return p, nil
+}
+
+// FieldMapper renames the fields of documents before writing them to w. A field mapped to the
+// empty string is removed from the document.
+type FieldMapper struct {
+	Fields map[string]string
+	W      DocumentWriter
+}
+
+func (m FieldMapper) Write(d Document) error {
+	for from, to := range m.Fields {
+		v, ok := d.Fields[from]
+		if !ok {
+			continue
+		}
+		delete(d.Fields, from)
+		if len(to) > 0 {
+			d.Fields[to] = v
+		}
+	}
+	return m.W.Write(d)
+}
+
+func (m FieldMapper) Close() error {
+	return m.W.Close()
+}
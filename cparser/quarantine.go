@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"html"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// QuarantineEntry describes a document which could not be parsed.
+type QuarantineEntry struct {
+	File   string `json:"file"`
+	Offset int64  `json:"offset"`
+	DocNo  string `json:"docno,omitempty"`
+	Error  string `json:"error"`
+}
+
+// Quarantine records documents which could not be parsed to an NDJSON file. Entries are always
+// logged, and only written to a file when Path is set. It is safe for concurrent use.
+type Quarantine struct {
+	Path string
+	N    int
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+// quarantine is where all the parsers record malformed documents.
+var quarantine = new(Quarantine)
+
+// Add records a malformed document.
+func (q *Quarantine) Add(e QuarantineEntry) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.N++
+	log.Printf("quarantined document %q in %s at offset %d: %s\n", e.DocNo, e.File, e.Offset, e.Error)
+	if len(q.Path) == 0 {
+		return nil
+	}
+	if q.f == nil {
+		f, err := os.OpenFile(q.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		q.f = f
+	}
+	return json.NewEncoder(q.f).Encode(e)
+}
+
+// Close closes the quarantine file.
+func (q *Quarantine) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.N > 0 {
+		log.Printf("quarantined %d documents\n", q.N)
+	}
+	if q.f == nil {
+		return nil
+	}
+	return q.f.Close()
+}
+
+var (
+	tagRe   = regexp.MustCompile(`<[^>]*>`)                // Regex to remove any tag.
+	docNoEl = regexp.MustCompile(`(?s)<DOCNO>.*?</DOCNO>`) // Regex to remove the DOCNO element.
+	spaceRe = regexp.MustCompile(`[ \t\r\n]+`)             // Regex to collapse whitespace.
+)
+
+// ParseTRECLenient extracts what it can from a TREC document that could not be decoded: the
+// DOCNO and the text between the tags. The document id is empty if the DOCNO was not found.
+func ParseTRECLenient(b []byte) (Document, error) {
+	var docNo string
+	if m := docNoRe.FindSubmatch(b); m != nil {
+		docNo = strings.TrimSpace(string(m[1]))
+	}
+	text := docNoEl.ReplaceAll(b, nil)
+	text = tagRe.ReplaceAll(text, []byte(" "))
+	text = spaceRe.ReplaceAll(text, []byte(" "))
+
+	j := struct {
+		DocNo string
+		Text  string
+	}{
+		DocNo: docNo,
+		Text:  strings.TrimSpace(html.UnescapeString(string(text))),
+	}
+	return NewDocument(docNo, j)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// MaxTRECDocBytes is the largest TREC document which is parsed. Larger documents are quarantined
+// rather than being held in memory.
+var MaxTRECDocBytes = 32 << 20
+
+// TRECDoc is a <DOC> element read from a TREC collection file, with the offsets of its first and
+// last bytes in the file. Bytes are only valid until the next document is read.
+type TRECDoc struct {
+	Bytes []byte
+	Start int64
+	End   int64 // Offset just past the end of the </DOC> tag.
+
+	// TooLarge is set when the document is larger than MaxBytes. Only its first MaxBytes are kept.
+	TooLarge bool
+}
+
+// Charset returns the charset declared for a document. Web pages (e.g., GOV2) declare their
+// charset in the HTTP headers of their DOCHDR, or in a <meta> tag.
+func (d TRECDoc) Charset() string {
+	page := d.Bytes
+	if i := bytes.Index(page, []byte(DocHdrToken)); i >= 0 {
+		page = page[i+len(DocHdrToken):]
+		if j := bytes.Index(page, []byte(DocHdrEndToken)); j >= 0 {
+			if cs := HTTPCharset(page[:j]); len(cs) > 0 {
+				return cs
+			}
+			page = page[j+len(DocHdrEndToken):]
+		}
+	}
+	return MetaCharset(page)
+}
+
+// TRECTokenizer reads the <DOC> elements of a TREC collection file one at a time. Rather than
+// reading the file line by line, it looks for the <DOC> and </DOC> tags anywhere in the file, so
+// a document may share a line with other documents, and lines may be of any length. Text between
+// documents is skipped.
+type TRECTokenizer struct {
+	MaxBytes int
+
+	r      *bufio.Reader
+	offset int64 // Offset of the next byte to be read.
+	buf    bytes.Buffer
+}
+
+// NewTRECTokenizer creates a tokenizer which reads documents of up to maxBytes from r. The
+// offsets of the documents start from the offset of r in its stream.
+func NewTRECTokenizer(r io.Reader, maxBytes int) *TRECTokenizer {
+	return &TRECTokenizer{
+		MaxBytes: maxBytes,
+		r:        bufio.NewReaderSize(r, 64<<10),
+		offset:   streamOffset(r),
+	}
+}
+
+// next reads up to and including the next tag, appending what it reads to the buffer when keep
+// is set. It reports whether the tag was found before the end of the file.
+func (t *TRECTokenizer) next(tag string, keep bool) (bool, error) {
+	for {
+		b, err := t.r.ReadSlice('<')
+		t.offset += int64(len(b))
+		if keep {
+			t.keep(b)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		// The '<' has been read, so compare the rest of the tag.
+		p, err := t.r.Peek(len(tag) - 1)
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+		if string(p) == tag[1:] {
+			if keep {
+				t.keep(p)
+			}
+			n, _ := t.r.Discard(len(p))
+			t.offset += int64(n)
+			return true, nil
+		}
+	}
+}
+
+// keep appends b to the current document, up to MaxBytes.
+func (t *TRECTokenizer) keep(b []byte) {
+	if t.MaxBytes > 0 && t.buf.Len()+len(b) > t.MaxBytes {
+		if t.buf.Len() >= t.MaxBytes {
+			return
+		}
+		b = b[:t.MaxBytes-t.buf.Len()]
+	}
+	t.buf.Write(b)
+}
+
+// Next reads the next document. It returns io.EOF once there are no more documents, and
+// io.ErrUnexpectedEOF along with the partial document if the file ends inside a document.
+func (t *TRECTokenizer) Next() (TRECDoc, error) {
+	ok, err := t.next(StartToken, false)
+	if err != nil {
+		return TRECDoc{}, err
+	}
+	if !ok {
+		return TRECDoc{}, io.EOF
+	}
+
+	start := t.offset - int64(len(StartToken))
+	t.buf.Reset()
+	t.buf.WriteString(StartToken)
+	ok, err = t.next(EndToken, true)
+	if err != nil {
+		return TRECDoc{}, err
+	}
+	d := TRECDoc{
+		Bytes:    t.buf.Bytes(),
+		Start:    start,
+		End:      t.offset,
+		TooLarge: t.MaxBytes > 0 && t.offset-start > int64(t.MaxBytes),
+	}
+	if !ok {
+		return d, io.ErrUnexpectedEOF
+	}
+	return d, nil
+}
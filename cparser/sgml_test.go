@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+// tokenize reads every document in a file, returning the error which ended it.
+func tokenize(file string, maxBytes int) ([]TRECDoc, error) {
+	var docs []TRECDoc
+	t := NewTRECTokenizer(strings.NewReader(file), maxBytes)
+	for {
+		d, err := t.Next()
+		if err == io.EOF {
+			return docs, nil
+		}
+		if err == nil || err == io.ErrUnexpectedEOF {
+			d.Bytes = append([]byte(nil), d.Bytes...)
+			docs = append(docs, d)
+		}
+		if err != nil {
+			return docs, err
+		}
+	}
+}
+
+func TestTRECTokenizerSharedLine(t *testing.T) {
+	file := "junk<DOC><DOCNO>1</DOCNO></DOC> <DOC><DOCNO>2</DOCNO></DOC>\n<DOC>\n<DOCNO>3</DOCNO>\n</DOC>\n"
+	docs, err := tokenize(file, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"<DOC><DOCNO>1</DOCNO></DOC>", "<DOC><DOCNO>2</DOCNO></DOC>", "<DOC>\n<DOCNO>3</DOCNO>\n</DOC>"}
+	if len(docs) != len(expected) {
+		t.Fatalf("read %d documents, expected %d", len(docs), len(expected))
+	}
+	for i, d := range docs {
+		if string(d.Bytes) != expected[i] {
+			t.Errorf("read %q, expected %q", d.Bytes, expected[i])
+		}
+		if file[d.Start:d.End] != expected[i] {
+			t.Errorf("document %d is at %d-%d, which is %q", i, d.Start, d.End, file[d.Start:d.End])
+		}
+	}
+}
+
+func TestTRECTokenizerLongLines(t *testing.T) {
+	text := strings.Repeat("x", 200<<10) // Longer than the 64KB buffer.
+	file := strings.Repeat("y", 100<<10) + "<DOC><TEXT>" + text + "</TEXT></DOC>"
+	docs, err := tokenize(file, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 || string(docs[0].Bytes) != "<DOC><TEXT>"+text+"</TEXT></DOC>" {
+		t.Fatalf("a document on a long line was not read whole")
+	}
+	if docs[0].Start != 100<<10 || docs[0].End != int64(len(file)) || docs[0].TooLarge {
+		t.Errorf("the document is at %d-%d (too large: %t)", docs[0].Start, docs[0].End, docs[0].TooLarge)
+	}
+}
+
+func TestTRECTokenizerUnterminated(t *testing.T) {
+	docs, err := tokenize("<DOC><DOCNO>1</DOCNO></DOC>\n<DOC><DOCNO>2</DOCNO><TEXT>cut", 0)
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("reading an unterminated document returned %v, expected %v", err, io.ErrUnexpectedEOF)
+	}
+	if len(docs) != 2 || string(docs[1].Bytes) != "<DOC><DOCNO>2</DOCNO><TEXT>cut" {
+		t.Errorf("read %d documents, expected the partial second document", len(docs))
+	}
+}
+
+func TestTRECTokenizerMaxBytes(t *testing.T) {
+	file := "<DOC><DOCNO>1</DOCNO><TEXT>some text</TEXT></DOC><DOC>2</DOC>"
+	for _, max := range []int{1, 5, 10} {
+		docs, err := tokenize(file, max)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(docs) != 2 || !docs[0].TooLarge || docs[1].TooLarge != (max < 12) {
+			t.Errorf("max %d: read %d documents, too large: %t", max, len(docs), docs[0].TooLarge)
+			continue
+		}
+		if l := len(docs[0].Bytes); l > max && l > len(StartToken) {
+			t.Errorf("max %d: kept %d bytes of a document which is too large", max, l)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"github.com/osirrc2019/ielab-docker/cparser/analysis"
+	"hash/fnv"
+	"math/bits"
+)
+
+// SimHashBands is the number of bands a fingerprint is split into to find candidate near
+// duplicates. Two fingerprints within SimHashBands-1 bits of each other share at least one band.
+const SimHashBands = 4
+
+// Words splits text into lower-case words, the same way as the analysis package.
+func Words(text string) []string {
+	return analysis.Tokenize(text)
+}
+
+// SimHash computes a 64-bit SimHash fingerprint of the k-word shingles of a document. Documents
+// with similar text have fingerprints that differ in only a few bits.
+func SimHash(words []string, k int) uint64 {
+	if len(words) < k {
+		k = len(words)
+	}
+	var v [64]int
+	h := fnv.New64a()
+	for i := 0; i+k <= len(words) && k > 0; i++ {
+		h.Reset()
+		for j, w := range words[i : i+k] {
+			if j > 0 {
+				h.Write([]byte{' '})
+			}
+			h.Write([]byte(w))
+		}
+		x := h.Sum64()
+		for b := 0; b < 64; b++ {
+			if x&(1<<uint(b)) != 0 {
+				v[b]++
+			} else {
+				v[b]--
+			}
+		}
+	}
+	var f uint64
+	for b := 0; b < 64; b++ {
+		if v[b] > 0 {
+			f |= 1 << uint(b)
+		}
+	}
+	return f
+}
+
+// HammingDistance counts the bits which differ between two fingerprints.
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// SimHashBand returns one band of a fingerprint, tagged with the band number so that bands can
+// share a single index.
+func SimHashBand(f uint64, band int) uint64 {
+	width := uint(64 / SimHashBands)
+	return uint64(band)<<width | (f>>(uint(band)*width))&(1<<width-1)
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FieldStats are the lengths, in words, of a field across the documents which have it.
+type FieldStats struct {
+	Documents int     `json:"documents"`
+	Average   float64 `json:"average_length"`
+	Median    int     `json:"median_length"`
+
+	total   int64
+	lengths map[int]int
+}
+
+// TermCount is the number of times a term occurs in a collection.
+type TermCount struct {
+	Term  string `json:"term"`
+	Count int    `json:"count"`
+}
+
+// CollectionStats summarise the documents parsed from a collection.
+type CollectionStats struct {
+	Documents      int                    `json:"documents"`
+	EmptyText      int                    `json:"empty_text"`
+	Files          map[string]int         `json:"files"`
+	SubCollections map[string]int         `json:"sub_collections"`
+	DuplicateIDs   map[string]int         `json:"duplicate_ids"`
+	Fields         map[string]*FieldStats `json:"fields"`
+	Tokens         int64                  `json:"tokens"`
+	Vocabulary     int                    `json:"vocabulary"`
+	TopTerms       []TermCount            `json:"top_terms"`
+}
+
+// StatsWriter collects statistics about the documents written to it rather than indexing them.
+// The text of a document is made up of its text fields, and documents are grouped into
+// sub-collections by their source (e.g., the source on TREC disks 4 and 5), or otherwise by the
+// top directory of their file beneath Root.
+type StatsWriter struct {
+	Root  string
+	Kinds map[string]FieldKind
+	Top   int // Number of top terms to report.
+
+	Stats CollectionStats
+
+	ids   map[string]int
+	terms map[string]int
+}
+
+// NewStatsWriter creates a writer which collects statistics.
+func NewStatsWriter(root string, kinds map[string]FieldKind, top int) *StatsWriter {
+	return &StatsWriter{
+		Root:  root,
+		Kinds: kinds,
+		Top:   top,
+		Stats: CollectionStats{
+			Files:          make(map[string]int),
+			SubCollections: make(map[string]int),
+			DuplicateIDs:   make(map[string]int),
+			Fields:         make(map[string]*FieldStats),
+		},
+		ids:   make(map[string]int),
+		terms: make(map[string]int),
+	}
+}
+
+// File records the number of documents parsed from a file, including files without documents.
+func (s *StatsWriter) File(path string, docs int) {
+	s.Stats.Files[path] = docs
+}
+
+// subCollection finds the sub-collection of a document.
+func (s *StatsWriter) subCollection(d Document) string {
+	for _, f := range []string{"Source", "source"} {
+		if v, ok := d.Fields[f].(string); ok && len(v) > 0 {
+			return v
+		}
+	}
+	file := d.Metadata["file"]
+	if len(s.Root) > 0 {
+		if rel, err := filepath.Rel(s.Root, file); err == nil {
+			file = rel
+		}
+	}
+	parts := strings.SplitN(filepath.ToSlash(file), "/", 2)
+	if len(parts) < 2 {
+		return "."
+	}
+	return parts[0]
+}
+
+// fieldLength counts the words in a field which is a string or a list of strings.
+func fieldLength(v interface{}) (int, bool) {
+	switch v := v.(type) {
+	case string:
+		return len(Words(v)), true
+	case []interface{}:
+		n := 0
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				n += len(Words(s))
+			}
+		}
+		return n, true
+	case []string:
+		n := 0
+		for _, s := range v {
+			n += len(Words(s))
+		}
+		return n, true
+	}
+	return 0, false
+}
+
+func (s *StatsWriter) Write(d Document) error {
+	s.Stats.Documents++
+	s.ids[d.ID]++
+	s.Stats.SubCollections[s.subCollection(d)]++
+
+	for name, v := range d.Fields {
+		n, ok := fieldLength(v)
+		if !ok {
+			continue
+		}
+		f := s.Stats.Fields[name]
+		if f == nil {
+			f = &FieldStats{lengths: make(map[int]int)}
+			s.Stats.Fields[name] = f
+		}
+		f.Documents++
+		f.total += int64(n)
+		f.lengths[n]++
+	}
+
+	words := Words(Contents(d, s.Kinds))
+	if len(words) == 0 {
+		s.Stats.EmptyText++
+	}
+	s.Stats.Tokens += int64(len(words))
+	for _, w := range words {
+		s.terms[w]++
+	}
+	return nil
+}
+
+// Close computes the statistics which need every document to have been seen.
+func (s *StatsWriter) Close() error {
+	if len(s.Stats.Files) == 0 && s.Stats.Documents > 0 {
+		s.Stats.Files["-"] = s.Stats.Documents
+	}
+	for id, n := range s.ids {
+		if n > 1 {
+			s.Stats.DuplicateIDs[id] = n
+		}
+	}
+
+	for _, f := range s.Stats.Fields {
+		f.Average = float64(f.total) / float64(f.Documents)
+		lengths := make([]int, 0, len(f.lengths))
+		for n := range f.lengths {
+			lengths = append(lengths, n)
+		}
+		sort.Ints(lengths)
+		seen := 0
+		for _, n := range lengths {
+			seen += f.lengths[n]
+			if seen*2 >= f.Documents {
+				f.Median = n
+				break
+			}
+		}
+	}
+
+	s.Stats.Vocabulary = len(s.terms)
+	s.Stats.TopTerms = make([]TermCount, 0, len(s.terms))
+	for t, n := range s.terms {
+		s.Stats.TopTerms = append(s.Stats.TopTerms, TermCount{Term: t, Count: n})
+	}
+	sort.Slice(s.Stats.TopTerms, func(i, j int) bool {
+		a, b := s.Stats.TopTerms[i], s.Stats.TopTerms[j]
+		if a.Count == b.Count {
+			return a.Term < b.Term
+		}
+		return a.Count > b.Count
+	})
+	if len(s.Stats.TopTerms) > s.Top {
+		s.Stats.TopTerms = s.Stats.TopTerms[:s.Top]
+	}
+	return nil
+}
+
+// sortedKeys returns the keys of a map of counts in order.
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Summary writes a human-readable summary of the statistics. Only the files without any
+// documents are listed, since collections can contain many thousands of files.
+func (c CollectionStats) Summary(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "documents:        %d\n", c.Documents)
+	fmt.Fprintf(&b, "files:            %d\n", len(c.Files))
+	fmt.Fprintf(&b, "empty text:       %d\n", c.EmptyText)
+	fmt.Fprintf(&b, "duplicate ids:    %d\n", len(c.DuplicateIDs))
+	fmt.Fprintf(&b, "tokens:           %d\n", c.Tokens)
+	fmt.Fprintf(&b, "vocabulary:       %d\n", c.Vocabulary)
+
+	var empty []string
+	for _, f := range sortedKeys(c.Files) {
+		if c.Files[f] == 0 {
+			empty = append(empty, f)
+		}
+	}
+	if len(empty) > 0 {
+		fmt.Fprintf(&b, "\nfiles without documents:\n")
+		for _, f := range empty {
+			fmt.Fprintf(&b, "  %s\n", f)
+		}
+	}
+
+	if len(c.DuplicateIDs) > 0 {
+		fmt.Fprintf(&b, "\nduplicate ids (up to 10):\n")
+		for i, id := range sortedKeys(c.DuplicateIDs) {
+			if i == 10 {
+				break
+			}
+			fmt.Fprintf(&b, "  %-30s %10d\n", id, c.DuplicateIDs[id])
+		}
+	}
+
+	fmt.Fprintf(&b, "\nsub-collections:\n")
+	for _, sc := range sortedKeys(c.SubCollections) {
+		fmt.Fprintf(&b, "  %-30s %10d\n", sc, c.SubCollections[sc])
+	}
+
+	names := make([]string, 0, len(c.Fields))
+	for name := range c.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(&b, "\n  %-30s %10s %10s %10s\n", "field", "documents", "average", "median")
+	for _, name := range names {
+		f := c.Fields[name]
+		fmt.Fprintf(&b, "  %-30s %10d %10.1f %10d\n", name, f.Documents, f.Average, f.Median)
+	}
+
+	if len(c.TopTerms) > 0 {
+		fmt.Fprintf(&b, "\ntop terms:\n")
+		for _, t := range c.TopTerms {
+			fmt.Fprintf(&b, "  %-30s %10d\n", t.Term, t.Count)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
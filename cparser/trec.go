@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+// TRECSource describes how to extract fields from the documents of one source on the TREC disks.
+// Documents are matched to a source using the prefix of their DOCNO, and the text of each element
+// in Fields is extracted into the named JSON field. Text nested in several mapped elements is
+// extracted into each of their fields.
+type TRECSource struct {
+	Name   string
+	Prefix string
+	Fields map[string]string
+}
+
+// TRECSources are the sources of TREC disks 4 and 5 (robust04).
+var TRECSources = []TRECSource{
+	{
+		Name:   "ft",
+		Prefix: "FT",
+		Fields: map[string]string{
+			"HEADLINE": "Headline",
+			"BYLINE":   "Byline",
+			"DATE":     "Date",
+			"DATELINE": "Dateline",
+			"PROFILE":  "Profile",
+			"PUB":      "Publication",
+			"PAGE":     "Page",
+			"TEXT":     "Text",
+		},
+	},
+	{
+		Name:   "fbis",
+		Prefix: "FBIS",
+		Fields: map[string]string{
+			"TI":    "Title",
+			"H2":    "Heading",
+			"DATE1": "Date",
+			"AU":    "Byline",
+			"TEXT":  "Text",
+		},
+	},
+	{
+		Name:   "latimes",
+		Prefix: "LA",
+		Fields: map[string]string{
+			"HEADLINE":   "Headline",
+			"BYLINE":     "Byline",
+			"DATE":       "Date",
+			"DATELINE":   "Dateline",
+			"SECTION":    "Section",
+			"GRAPHIC":    "Graphic",
+			"TYPE":       "Type",
+			"CORRECTION": "Correction",
+			"TEXT":       "Text",
+		},
+	},
+	{
+		Name:   "fr94",
+		Prefix: "FR94",
+		Fields: map[string]string{
+			"DOCTITLE": "Title",
+			"AGENCY":   "Agency",
+			"USDEPT":   "Department",
+			"USBUREAU": "Bureau",
+			"ACTION":   "Action",
+			"SUMMARY":  "Summary",
+			"DATE":     "Date",
+			"TEXT":     "Text",
+		},
+	},
+}
+
+// TRECSourceFor finds the source of a document using its DOCNO.
+func TRECSourceFor(docNo string) (TRECSource, bool) {
+	for _, s := range TRECSources {
+		if strings.HasPrefix(docNo, s.Prefix) {
+			return s, true
+		}
+	}
+	return TRECSource{}, false
+}
+
+// Extract extracts the fields of a document from this source. The result always contains the
+// DocNo and the Source of the document.
+func (s TRECSource) Extract(docNo string, b []byte) (map[string]interface{}, error) {
+	var (
+		fields = make(map[string]*strings.Builder)
+		stack  []string
+		d      = xml.NewDecoder(bytes.NewReader(b))
+	)
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name.Local)
+		case xml.EndElement:
+			if len(stack) > 0 {
+				// Separate the text of consecutive elements.
+				if f, ok := s.Fields[stack[len(stack)-1]]; ok && fields[f] != nil {
+					fields[f].WriteByte(' ')
+				}
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			seen := make(map[string]bool)
+			for _, el := range stack {
+				f, ok := s.Fields[el]
+				if !ok || seen[f] {
+					continue
+				}
+				seen[f] = true
+				if fields[f] == nil {
+					fields[f] = new(strings.Builder)
+				}
+				fields[f].Write(t)
+				fields[f].WriteByte(' ')
+			}
+		}
+	}
+
+	j := map[string]interface{}{
+		"DocNo":  docNo,
+		"Source": s.Name,
+	}
+	for f, text := range fields {
+		if v := normaliseSpace(text.String()); len(v) > 0 {
+			j[f] = v
+		}
+	}
+	if _, ok := j["Text"]; !ok {
+		j["Text"] = ""
+	}
+	return j, nil
+}
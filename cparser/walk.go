@@ -0,0 +1,204 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// globs is a repeatable command-line flag of glob patterns.
+type globs []string
+
+func (g *globs) String() string {
+	return strings.Join(*g, ",")
+}
+
+func (g *globs) Set(value string) error {
+	if _, err := filepath.Match(value, ""); err != nil {
+		return err
+	}
+	*g = append(*g, value)
+	return nil
+}
+
+// Match reports whether the slash-separated path, relative to the collection root, matches any of
+// the patterns. A pattern matches either the whole relative path or just its base name.
+func (g globs) Match(rel string) bool {
+	for _, pattern := range g {
+		if ok, _ := filepath.Match(pattern, rel); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, filepath.Base(rel)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// pathWriter records the collection file documents were parsed from.
+type pathWriter struct {
+	path string
+	w    DocumentWriter
+}
+
+func (p pathWriter) Write(d Document) error {
+	d.SetMetadata("path", p.path)
+	return p.w.Write(d)
+}
+
+func (p pathWriter) Close() error {
+	return p.w.Close()
+}
+
+// Walker parses every file in a collection directory using a pool of parse workers. Documents
+// are written in the order of the files in the collection, and of the documents in each file.
+type Walker struct {
+	Root    string
+	Format  CollectionFormat
+	Include globs
+	Exclude globs
+	Workers int
+	Buffer  int // Number of documents buffered for each file being parsed.
+
+	// Done is called, if set, with the number of documents parsed from each file.
+	Done func(path string, docs int)
+
+	// Checkpoint, if set, records each file once it has been parsed, and files it has already
+	// recorded are skipped.
+	Checkpoint *Checkpoint
+
+	Files   int
+	Failed  int
+	Skipped int
+	Docs    int
+}
+
+// files lists the files in the collection which pass the include and exclude rules.
+func (c *Walker) files() ([]string, error) {
+	var paths []string
+	err := filepath.Walk(c.Root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(c.Root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			return nil
+		}
+		if c.Exclude.Match(rel) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() || !info.Mode().IsRegular() {
+			return nil
+		}
+		if len(c.Include) > 0 && !c.Include.Match(rel) {
+			return nil
+		}
+		if c.Checkpoint != nil {
+			done, err := c.Checkpoint.Completed(path, info.Size())
+			if err != nil {
+				return err
+			}
+			if done {
+				c.Skipped++
+				return nil
+			}
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	return paths, err
+}
+
+// parseFile parses a single collection file, decompressing it if necessary. With a checkpoint,
+// the file is hashed as it is parsed.
+func (c *Walker) parseFile(path string, w DocumentWriter) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if c.Checkpoint == nil {
+		return ParseCompressed(path, f, c.Format, w)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	h := sha256.New()
+	n, err := ParseCompressed(path, io.TeeReader(f, h), c.Format, pathWriter{path: path, w: w})
+	if err != nil {
+		return n, err
+	}
+	// Parsers may stop before the end of the file, e.g., at the end of a tar archive.
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return n, err
+	}
+	return n, c.Checkpoint.Parsed(path, info.Size(), hex.EncodeToString(h.Sum(nil)), n)
+}
+
+// Walk parses every file in the collection and writes the documents to w. A file which cannot be
+// parsed is logged and counted, but does not stop the walk.
+func (c *Walker) Walk(w DocumentWriter) error {
+	if c.Format != Auto {
+		if _, err := FormatFor(c.Format); err != nil {
+			return err
+		}
+	}
+
+	paths, err := c.files()
+	if err != nil {
+		return err
+	}
+
+	jobs := make(chan ParseJob)
+	go func() {
+		for _, path := range paths {
+			path := path
+			jobs <- ParseJob{Name: path, Parse: func(w DocumentWriter) (int, error) {
+				return c.parseFile(path, w)
+			}}
+		}
+		close(jobs)
+	}()
+
+	err = Pipeline{Workers: c.Workers, Buffer: c.Buffer}.Run(jobs, w, func(path string, docs int, err error) error {
+		c.Files++
+		c.Docs += docs
+		if c.Done != nil {
+			c.Done(path, docs)
+		}
+		if err != nil {
+			c.Failed++
+			log.Printf("[X] %s (%d/%d): %v\n", path, c.Files, len(paths), err)
+			return nil
+		}
+		log.Printf("[√] %s (%d/%d): %d documents\n", path, c.Files, len(paths), docs)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if c.Skipped > 0 {
+		log.Printf("skipped %d files which were already indexed\n", c.Skipped)
+	}
+	log.Printf("parsed %d documents from %d files (%d failed)\n", c.Docs, c.Files, c.Failed)
+	if c.Failed > 0 && c.Failed == c.Files {
+		return fmt.Errorf("none of the %d files in %s could be parsed", c.Files, c.Root)
+	}
+	return nil
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/datatogether/warc"
+)
+
+// MaxWARCRecordSize is the largest record content that will be read. A record claiming to be
+// larger than this is treated as malformed.
+const MaxWARCRecordSize = 256 << 20
+
+// errCorruptMember is returned when a gzip member is corrupt and had to be skipped.
+var errCorruptMember = errors.New("warc: corrupt gzip member")
+
+// maxMemberRewind is the most compressed bytes of a gzip member kept in case it is corrupt. A
+// member larger than this (e.g., a whole file compressed as one stream) cannot be re-read, so
+// after a corrupt one reading continues from where the corruption was found.
+const maxMemberRewind = MaxWARCRecordSize
+
+// memberSource is the compressed input of gzipMembers. The bytes of the current member are kept
+// as they are read, so that if the member turns out to be corrupt, reading can start again from
+// just after its first byte.
+type memberSource struct {
+	src     *bufio.Reader
+	pending []byte // Bytes to read before src, after a rewind.
+	member  []byte // Bytes of the current member read so far.
+	lost    bool   // Whether the member was too large to keep.
+}
+
+// keep records bytes of the current member.
+func (s *memberSource) keep(b []byte) {
+	if s.lost {
+		return
+	}
+	if len(s.member)+len(b) > maxMemberRewind {
+		s.member, s.lost = nil, true
+		return
+	}
+	s.member = append(s.member, b...)
+}
+
+func (s *memberSource) Read(p []byte) (int, error) {
+	if len(s.pending) > 0 {
+		n := copy(p, s.pending)
+		s.pending = s.pending[n:]
+		s.keep(p[:n])
+		return n, nil
+	}
+	n, err := s.src.Read(p)
+	s.keep(p[:n])
+	return n, err
+}
+
+func (s *memberSource) ReadByte() (byte, error) {
+	var b byte
+	if len(s.pending) > 0 {
+		b, s.pending = s.pending[0], s.pending[1:]
+	} else {
+		var err error
+		b, err = s.src.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+	}
+	if !s.lost && len(s.member) < maxMemberRewind {
+		s.member = append(s.member, b)
+	} else {
+		s.member, s.lost = nil, true
+	}
+	return b, nil
+}
+
+// peek returns up to the next n bytes without reading them.
+func (s *memberSource) peek(n int) ([]byte, error) {
+	if len(s.pending) >= n {
+		return s.pending[:n], nil
+	}
+	b, err := s.src.Peek(n - len(s.pending))
+	return append(append([]byte(nil), s.pending...), b...), err
+}
+
+// start starts a new member at the current position.
+func (s *memberSource) start() {
+	s.member, s.lost = s.member[:0], false
+}
+
+// rewind goes back to just after the first byte of the current member, or skips a byte if none
+// of the member was kept.
+func (s *memberSource) rewind() error {
+	if len(s.member) == 0 {
+		if len(s.pending) > 0 {
+			s.pending = s.pending[1:]
+			return nil
+		}
+		_, err := s.src.ReadByte()
+		return err
+	}
+	s.pending = append(append([]byte(nil), s.member[1:]...), s.pending...)
+	s.member = s.member[:0]
+	return nil
+}
+
+// gzipMembers reads a file made of concatenated gzip members, as in a .warc.gz file, where each
+// record is usually compressed separately. A corrupt or truncated member is skipped rather than
+// ending the stream: the reader looks for the next gzip header from just after the start of the
+// corrupt member, so a member which follows a truncated one is not lost, and returns
+// errCorruptMember once.
+type gzipMembers struct {
+	src  memberSource
+	gz   gzip.Reader
+	done bool
+}
+
+func newGzipMembers(src *bufio.Reader) (*gzipMembers, error) {
+	g := &gzipMembers{src: memberSource{src: src}}
+	if err := g.reset(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// validGzipHeader reports whether b starts with a plausible gzip member header: the magic bytes,
+// the deflate method, no reserved flags and a known compression level.
+func validGzipHeader(b []byte) bool {
+	return len(b) >= 10 && bytes.HasPrefix(b, gzipMagic) && b[2] == 8 && b[3]&0xe0 == 0 &&
+		(b[8] == 0 || b[8] == 2 || b[8] == 4)
+}
+
+// reset starts reading the member at the current position, checking its header before reading it.
+func (g *gzipMembers) reset() error {
+	g.src.start()
+	header, _ := g.src.peek(10)
+	if !validGzipHeader(header) {
+		return gzip.ErrHeader
+	}
+	err := g.gz.Reset(&g.src)
+	if err != nil {
+		return err
+	}
+	g.gz.Multistream(false)
+	return nil
+}
+
+func (g *gzipMembers) Read(p []byte) (int, error) {
+	if g.done {
+		return 0, io.EOF
+	}
+	for {
+		n, err := g.gz.Read(p)
+		if err == io.EOF {
+			// Move on to the next member, unless this was the last one.
+			if _, perr := g.src.peek(1); perr != nil {
+				g.done = true
+				return n, io.EOF
+			}
+			err = g.reset()
+			if err == nil {
+				if n > 0 {
+					return n, nil
+				}
+				continue
+			}
+		}
+		if err != nil {
+			if rerr := g.resync(); rerr != nil {
+				g.done = true
+			}
+			return n, errCorruptMember
+		}
+		return n, nil
+	}
+}
+
+// resync starts reading again from just after the start of the corrupt member, and discards input
+// until the next member which starts correctly.
+func (g *gzipMembers) resync() error {
+	for {
+		if err := g.src.rewind(); err != nil {
+			return io.EOF
+		}
+		if _, err := g.src.peek(1); err != nil {
+			return io.EOF
+		}
+		if g.reset() == nil {
+			return nil
+		}
+	}
+}
+
+// WARCReader reads one record at a time from a WARC file, which may be compressed with a gzip
+// member per record. A malformed record is skipped, and reading continues from the next record.
+type WARCReader struct {
+	r         *bufio.Reader
+	Malformed int
+}
+
+// NewWARCReader creates a reader of the WARC records in r.
+func NewWARCReader(r io.Reader) (*WARCReader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(3)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if bytes.HasPrefix(magic, gzipMagic) {
+		g, err := newGzipMembers(br)
+		if err != nil {
+			return nil, err
+		}
+		br = bufio.NewReader(g)
+	}
+	return &WARCReader{r: br}, nil
+}
+
+// Next returns the next well-formed record, or io.EOF once there are no more records.
+func (w *WARCReader) Next() (*warc.Record, error) {
+	for {
+		rec, err := w.record()
+		if err == nil {
+			return rec, nil
+		}
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		w.Malformed++
+		log.Printf("skipping malformed WARC record: %v\n", err)
+	}
+}
+
+// record reads the next record. Any data before the version line of the record is discarded, which
+// allows the reader to recover after a malformed record.
+func (w *WARCReader) record() (*warc.Record, error) {
+	var version string
+	for {
+		line, err := w.r.ReadString('\n')
+		if err == errCorruptMember {
+			return nil, err
+		}
+		if err != nil && len(line) == 0 {
+			return nil, io.EOF
+		}
+		if strings.HasPrefix(line, "WARC/") {
+			version = strings.TrimSpace(line)
+			break
+		}
+		if err != nil {
+			return nil, io.EOF
+		}
+	}
+
+	rec := &warc.Record{
+		Format:  warc.RecordFormatUnknown,
+		Headers: warc.Header{},
+	}
+	if version == "WARC/1.0" {
+		rec.Format = warc.RecordFormatWarc
+	}
+
+	// Read the headers up to the blank line that separates them from the content.
+	for {
+		line, err := w.r.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("reading headers: %v", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if len(line) == 0 {
+			break
+		}
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			return nil, fmt.Errorf("invalid header line %q", line)
+		}
+		rec.Headers.Set(line[:i], strings.TrimSpace(line[i+1:]))
+	}
+	rec.Type = warc.ParseRecordType(rec.Headers.Get(warc.FieldNameWARCType))
+
+	length, err := strconv.ParseInt(rec.Headers.Get(warc.FieldNameContentLength), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Length: %v", err)
+	}
+	if length < 0 || length > MaxWARCRecordSize {
+		return nil, fmt.Errorf("invalid Content-Length %d", length)
+	}
+
+	content := make([]byte, length)
+	_, err = io.ReadFull(w.r, content)
+	if err != nil {
+		return nil, fmt.Errorf("reading content: %v", err)
+	}
+	rec.Content = bytes.NewBuffer(content)
+	return rec, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// warcRecord creates a WARC response record.
+func warcRecord(id string) []byte {
+	content := fmt.Sprintf("HTTP/1.1 200 OK\r\n\r\n<html><body>page %s</body></html>", id)
+	return []byte(fmt.Sprintf("WARC/1.0\r\nWARC-Type: response\r\nWARC-TREC-ID: %s\r\nContent-Length: %d\r\n\r\n%s\r\n\r\n", id, len(content), content))
+}
+
+// gzipMember compresses a record as a gzip member of its own.
+func gzipMember(t *testing.T, b []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// readWARC reads the ids of the records in a file, and how many were malformed.
+func readWARC(t *testing.T, data []byte) (string, int) {
+	r, err := NewWARCReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, rec.Headers.Get("WARC-TREC-ID"))
+	}
+	return strings.Join(ids, ","), r.Malformed
+}
+
+func TestWARCReaderGzipMembers(t *testing.T) {
+	var members [][]byte
+	for i := 0; i < 4; i++ {
+		members = append(members, gzipMember(t, warcRecord(fmt.Sprintf("id-%d", i))))
+	}
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+	corrupt := append([]byte(nil), members[1]...)
+	for i := 12; i < len(corrupt)-8; i++ {
+		corrupt[i] ^= 0x55
+	}
+	// A false header with the largest extra field, which would swallow the following members.
+	falseHeader := []byte{0x1f, 0x8b, 0x08, 0x04, 0, 0, 0, 0, 0, 0xff, 0xff, 0xff}
+
+	for _, c := range []struct {
+		name      string
+		data      []byte
+		ids       string
+		malformed bool
+	}{
+		{"valid", join(members...), "id-0,id-1,id-2,id-3", false},
+		{"corrupt", join(members[0], corrupt, members[2], members[3]), "id-0,id-2,id-3", true},
+		{"truncated", join(members[0], members[1][:len(members[1])/2], members[2], members[3]), "id-0,id-2,id-3", true},
+		{"truncated header", join(members[0], members[1][:5], members[2], members[3]), "id-0,id-2,id-3", true},
+		{"false header", join(members[0], falseHeader, members[2], members[3]), "id-0,id-2,id-3", true},
+		{"garbage", join(members[0], []byte("garbage\x1f\x8b"), members[2], members[3]), "id-0,id-2,id-3", true},
+		{"truncated last", join(members[0], members[1], members[2][:len(members[2])/2]), "id-0,id-1", true},
+		{"corrupt first", join(corrupt, members[2]), "id-2", true},
+	} {
+		ids, malformed := readWARC(t, c.data)
+		if ids != c.ids {
+			t.Errorf("%s: read %s, expected %s", c.name, ids, c.ids)
+		}
+		if (malformed > 0) != c.malformed {
+			t.Errorf("%s: %d malformed records", c.name, malformed)
+		}
+	}
+}
+
+func TestWARCReaderUncompressed(t *testing.T) {
+	data := bytes.Join([][]byte{warcRecord("id-0"), []byte("WARC/1.0\r\nContent-Length: x\r\n\r\n"), warcRecord("id-1")}, nil)
+	ids, malformed := readWARC(t, data)
+	if ids != "id-0,id-1" || malformed != 1 {
+		t.Errorf("read %s with %d malformed records, expected id-0,id-1 with 1", ids, malformed)
+	}
+}
@@ -5,11 +5,13 @@ INDEX=$1
 TOPIC_PATH=$2
 TOPIC_FORMAT=$3
 TOP_K=$4
+VARIANT=$5
+COLLAPSE=$6
 
 ./eswait.sh
 
 # Perform the search.
-cat ${TOPIC_PATH} | ./ielab_tsearcher ${INDEX} ${TOPIC_FORMAT} ${TOP_K} > output/${INDEX}-${TOP_K}.run
+cat ${TOPIC_PATH} | ./ielab_tsearcher -variant "${VARIANT}" -collapse "${COLLAPSE}" ${INDEX} ${TOPIC_FORMAT} ${TOP_K} > output/${INDEX}${VARIANT:+-${VARIANT}}${COLLAPSE:+-collapsed}-${TOP_K}.run
 
 echo "############### BEGIN ELASTICSEARCH LOGS ###############"
 cat /elasticsearch/logs/elasticsearch.log
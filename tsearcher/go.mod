@@ -5,4 +5,7 @@ go 1.12
 require (
 	github.com/hscells/trecresults v0.0.0-20190325033736-14d24278e775
 	github.com/olivere/elastic/v7 v7.0.0
+	github.com/osirrc2019/ielab-docker/cparser v0.0.0
 )
+
+replace github.com/osirrc2019/ielab-docker/cparser => ../cparser
@@ -5,14 +5,17 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"flag"
 	"fmt"
 	"github.com/hscells/trecresults"
 	"github.com/olivere/elastic/v7"
+	"github.com/osirrc2019/ielab-docker/cparser/analysis"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -103,6 +106,56 @@ func ParseTRECTopic(r io.Reader) (Topic, error) {
 	return topic, nil
 }
 
+// TextFields finds the text fields of an index, as mapped by cparser. With a variant, the
+// subfields of the text fields which are analysed by that variant (e.g., text.raw) are found
+// instead.
+func TextFields(ctx context.Context, client *elastic.Client, index, variant string) ([]string, error) {
+	mappings, err := client.GetMapping().Index(index).Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for _, m := range mappings {
+		mapping, _ := m.(map[string]interface{})
+		mapping, _ = mapping["mappings"].(map[string]interface{})
+		properties, _ := mapping["properties"].(map[string]interface{})
+		textFields("", properties, variant, seen)
+	}
+	if len(seen) == 0 {
+		if len(variant) > 0 {
+			return nil, fmt.Errorf("index %s has no text fields with the %s variant", index, variant)
+		}
+		return nil, fmt.Errorf("index %s has no text fields", index)
+	}
+	var fields []string
+	for f := range seen {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	return fields, nil
+}
+
+func textFields(prefix string, properties map[string]interface{}, variant string, seen map[string]bool) {
+	for name, p := range properties {
+		property, _ := p.(map[string]interface{})
+		if nested, ok := property["properties"].(map[string]interface{}); ok {
+			textFields(prefix+name+".", nested, variant, seen)
+			continue
+		}
+		if property["type"] != "text" {
+			continue
+		}
+		if len(variant) == 0 {
+			seen[prefix+name] = true
+			continue
+		}
+		subfields, _ := property["fields"].(map[string]interface{})
+		if _, ok := subfields[variant]; ok {
+			seen[prefix+name+"."+variant] = true
+		}
+	}
+}
+
 func main() {
 	var (
 		buff  = new(bytes.Buffer)          // Buffer to store the current document.
@@ -110,15 +163,21 @@ func main() {
 		re    = regexp.MustCompile("&.*;") // Regex to filter out XML entities.
 	)
 
-	collection := os.Args[1]
-	topicFormat := TopicFormat(os.Args[2])
+	variant := flag.String("variant", "", "analysis variant of the text fields to search, e.g., raw (the text fields themselves by default)")
+	collapse := flag.String("collapse", "", "keyword field to collapse results on, e.g., cluster_id to return one of each cluster of near duplicates")
+	pretokenized := flag.String("pretokenized", "", "analyzer the index was pre-tokenized with by cparser, to analyse queries the same way: raw, stopped or porter")
+	stopwords := flag.String("stopwords", "lucene", "stop list the index was pre-tokenized with: lucene, smart or inquery")
+	flag.Parse()
+
+	collection := flag.Arg(0)
+	topicFormat := TopicFormat(flag.Arg(1))
 	switch topicFormat {
 	case TREC:
 		// There is only one format supported currently.
 	default:
 		log.Fatalf("%s is not a known topic format", topicFormat)
 	}
-	topK, err := strconv.Atoi(os.Args[3])
+	topK, err := strconv.Atoi(flag.Arg(2))
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -128,6 +187,30 @@ func main() {
 		log.Fatalln(err)
 	}
 
+	// Only the text fields (or one of their variants) are searched. Runs of a variant are named
+	// after it, so that they can be told apart.
+	runName := collection
+	if len(*variant) > 0 {
+		runName += "-" + *variant
+	}
+	if len(*collapse) > 0 {
+		runName += "-collapsed"
+	}
+	fields, err := TextFields(context.Background(), client, collection, *variant)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// Queries of a pre-tokenized index are analysed the same way as its documents were.
+	var analyzer *analysis.Analyzer
+	if len(*pretokenized) > 0 {
+		a, err := analysis.Named(*pretokenized, *stopwords)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		analyzer = &a
+	}
+
 	queryRe := regexp.MustCompile("[^a-zA-Z0-9_ ]+")
 
 	// Read and parse the collection.
@@ -154,15 +237,25 @@ func main() {
 			}
 
 			query := strings.TrimSpace(queryRe.ReplaceAllString(topic.Title, ""))
+			if analyzer != nil {
+				query = strings.Join(analyzer.Analyze(query), " ")
+			}
 
-			log.Printf("index: %s, format: %s, query: %s\n", collection, topicFormat, query)
+			log.Printf("index: %s, format: %s, variant: %s, query: %s\n", collection, topicFormat, *variant, query)
 
 			// Execute the topic.
-			search, err := client.
+			q := elastic.NewQueryStringQuery(query)
+			for _, f := range fields {
+				q = q.Field(f)
+			}
+			s := client.
 				Search(collection).
 				Size(topK).
-				Query(elastic.NewQueryStringQuery(query)).
-				Do(context.Background())
+				Query(q)
+			if len(*collapse) > 0 {
+				s = s.Collapse(elastic.NewCollapseBuilder(*collapse))
+			}
+			search, err := s.Do(context.Background())
 			if err != nil {
 				log.Fatalln(err)
 			}
@@ -174,7 +267,7 @@ func main() {
 					DocId:     hit.Id,
 					Rank:      int64(i + 1),
 					Score:     *hit.Score,
-					RunName:   collection,
+					RunName:   runName,
 				}
 				_, err := os.Stdout.WriteString(fmt.Sprintf("%s\n", t.String()))
 				if err != nil {